@@ -1,63 +1,136 @@
 package main
 
 import (
+	"sync"
+
 	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/github"
+	"cmd/vossibility-collector/state"
 	"cmd/vossibility-collector/storage"
 
 	log "github.com/Sirupsen/logrus"
+	gh "github.com/google/go-github/github"
 	"github.com/mattbaird/elastigo/api"
 )
 
+// configureElasticSearch guards the configuration of the Elastic Search client
+// library.
+var configureElasticSearch sync.Once
+
 // Config is the global configuration for the tool.
 type Config struct {
 	ElasticSearch       string
 	GitHubAPIToken      string
 	PeriodicSync        config.PeriodicSync
+	StateFile           string
 	NSQ                 config.NSQConfig
+	Cache               config.CacheConfig
+	Enrichments         []github.Enrichment
+	Traffic             bool
+	Webhook             config.WebhookConfig
+	DeadLetter          config.DeadLetterConfig
+	Deduplication       config.DeduplicationConfig
 	NotAnalyzedPatterns []string
+	Organizations       map[string]config.OrganizationConfig
 	Repositories        map[string]*storage.Repository
+
+	// discovered maps the given name of discovered repositories to the name
+	// of the organization they belong to.
+	discovered map[string]string
+
+	// serialized is kept to create the discovered repositories.
+	serialized *config.SerializedConfig
 }
 
 // configFromFile creates a Config object from its serialized counterpart.
-func configFromFile(c *config.SerializedConfig) *Config {
+func configFromFile(c *config.SerializedConfig) (*Config, error) {
 	out := &Config{
 		ElasticSearch:       c.ElasticSearch,
 		GitHubAPIToken:      c.GitHubAPIToken,
+		StateFile:           c.StateFile,
 		NSQ:                 c.NSQ,
+		Cache:               c.Cache,
+		Traffic:             c.Sync.Traffic,
+		Webhook:             c.Webhook,
+		DeadLetter:          c.DeadLetter,
+		Deduplication:       c.Deduplication,
 		NotAnalyzedPatterns: c.Mapping[config.MappingNotAnalyzedKey],
+		Organizations:       c.Organizations,
 		Repositories:        make(map[string]*storage.Repository),
+		discovered:          make(map[string]string),
+		serialized:          c,
 	}
 
 	// Create periodic sync.
 	p, err := config.NewPeriodicSync(c.PeriodicSync)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	out.PeriodicSync = p
 
+	// Validate pull request enrichments.
+	if out.Enrichments, err = github.ParseEnrichments(c.Sync.Enrich); err != nil {
+		return nil, err
+	}
+
 	// Create repositories.
 	for name, config := range c.Repositories {
 		repo, err := storage.NewRepository(name, &config, c)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		repo.PeriodicSync = p
 		out.Repositories[name] = repo
 	}
-	return out
+	return out, nil
+}
+
+// RepositoryList returns the configured repositories as a slice.
+func (c *Config) RepositoryList() []*storage.Repository {
+	repos := make([]*storage.Repository, 0, len(c.Repositories))
+	for _, r := range c.Repositories {
+		repos = append(repos, r)
+	}
+	return repos
 }
 
 // ParseConfig returns a Config object from the requested filename and any
 // error encountered during load.
 func ParseConfig(filename string) (*Config, error) {
-	config, err := config.ParseRawConfiguration(filename)
+	serialized, err := config.ParseRawConfiguration(filename)
 	if err != nil {
 		return nil, err
 	}
+	c, err := configFromFile(serialized)
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure the Elastic Search client library once and for all: the
+	// configuration may be parsed again when reloaded, but the Elastic Search
+	// host can't be changed while running.
+	configureElasticSearch.Do(func() {
+		api.Hosts = append(api.Hosts, c.ElasticSearch)
+	})
+	return c, nil
+}
+
+// NewGitHubClient creates a GitHub API client according to the configuration.
+func NewGitHubClient(c *Config) *gh.Client {
+	return github.NewClientWithOptions(&github.ClientOptions{
+		Token:        c.GitHubAPIToken,
+		CachePath:    c.Cache.Path,
+		CacheMaxSize: int64(c.Cache.MaxSize) << 20,
+	})
+}
 
-	// Configure the Elastic Search client library once and for all.
-	api.Hosts = append(api.Hosts, config.ElasticSearch)
-	return configFromFile(config), nil
+// OpenStateOrDie returns the persisted state, and exits in case of error.
+func OpenStateOrDie(c *Config) *state.File {
+	s, err := state.Open(c.StateFile)
+	if err != nil {
+		log.Fatalf("failed to load state file %q: %v", c.StateFile, err)
+	}
+	return s
 }
 
 // ParseConfigOrDie returns a Config object from the requested filename and
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"path"
 
 	"github.com/BurntSushi/toml"
 )
@@ -20,11 +21,50 @@ const (
 	DefaultEventSet = "default"
 )
 
+const (
+	// SourceNSQ is the live events source for repositories which receive
+	// their events from an NSQ topic.
+	SourceNSQ = "nsq"
+
+	// SourcePoll is the live events source for repositories which poll their
+	// events from the GitHub Events API.
+	SourcePoll = "poll"
+)
+
 const (
 	GitHubTypeIssue         = "issue"
 	GitHubTypePullRequest   = "pull_request"
 	SnapshotIssueType       = "snapshot_issue"
 	SnapshotPullRequestType = "snapshot_pull_request"
+
+	GitHubTypeIssueComment    = "issue_comment"
+	GitHubTypeReviewComment   = "pull_request_review_comment"
+	SnapshotIssueCommentType  = "snapshot_issue_comment"
+	SnapshotReviewCommentType = "snapshot_review_comment"
+
+	GitHubTypeIssueEvent   = "issue_event"
+	SnapshotIssueEventType = "snapshot_issue_event"
+
+	GitHubTypeRelease   = "release"
+	GitHubTypeTag       = "tag"
+	SnapshotReleaseType = "snapshot_release"
+	SnapshotTagType     = "snapshot_tag"
+
+	GitHubTypeMilestone   = "milestone"
+	GitHubTypeLabel       = "label"
+	SnapshotMilestoneType = "snapshot_milestone"
+	SnapshotLabelType     = "snapshot_label"
+
+	GitHubTypeWorkflowRun   = "workflow_run"
+	SnapshotWorkflowRunType = "snapshot_workflow_run"
+
+	GitHubTypeRepository   = "repository"
+	SnapshotRepositoryType = "snapshot_repository"
+
+	GitHubTypeTrafficViews    = "traffic_views"
+	GitHubTypeTrafficClones   = "traffic_clones"
+	GitHubTypeTrafficReferrer = "traffic_referrer"
+	GitHubTypeTrafficPath     = "traffic_path"
 )
 
 const (
@@ -47,9 +87,76 @@ const (
 
 // NSQConfig is the configuration for NSQ.
 type NSQConfig struct {
+	// Topic is the optional topic shared by all repositories which don't
+	// define their own. Messages on that topic are routed to the repository
+	// matching their payload.
 	Topic   string `json:"topic"`
 	Channel string `json:"channel"`
 	Lookupd string `json:"lookup_address"`
+
+	// CatchAll is the optional given name of the repository which receives
+	// the messages from the shared topic that match no configured repository.
+	CatchAll string `json:"catch_all" toml:"catch_all"`
+
+	// CatchAllSecret is the optional secret shared with GitHub to sign the
+	// deliveries received by the catch-all repository.
+	CatchAllSecret string `json:"catch_all_secret" toml:"catch_all_secret"`
+}
+
+// WebhookConfig is the configuration for the builtin GitHub webhook receiver.
+type WebhookConfig struct {
+	// Listen is the address the HTTP server listens on (e.g., ":8080").
+	Listen string
+
+	// Path is the URL path at which GitHub deliveries are accepted.
+	Path string
+}
+
+// CacheConfig is the configuration for the cache of GitHub API responses.
+type CacheConfig struct {
+	// Path is the directory where responses are cached.
+	Path string
+
+	// MaxSize is the maximum total size of cached responses in megabytes, or
+	// zero for no limit.
+	MaxSize int `toml:"max_size"`
+}
+
+// SyncConfig is the configuration for synchronization jobs.
+type SyncConfig struct {
+	// Enrich is the list of additional data to retrieve for each pull
+	// request (such as "reviews").
+	Enrich []string
+
+	// Traffic enables the collection of the traffic statistics of
+	// repositories on each periodic sync.
+	Traffic bool
+}
+
+// DeadLetterConfig is the configuration for the spool of live events which
+// failed to be processed.
+type DeadLetterConfig struct {
+	// Path is the directory where failed events are stored. The dead letter
+	// spool is disabled when left empty.
+	Path string
+
+	// MaxAttempts is the number of processing attempts after which an event
+	// is sent to the spool.
+	MaxAttempts int `toml:"max_attempts"`
+}
+
+// DeduplicationConfig is the configuration for the detection of duplicated
+// live events deliveries.
+type DeduplicationConfig struct {
+	// Size is the maximum number of delivery identifiers to remember.
+	Size int
+
+	// TTL is the duration for which a delivery identifier is remembered (in
+	// the time.ParseDuration format).
+	TTL string
+
+	// Path is the optional file where delivery identifiers are persisted.
+	Path string
 }
 
 // RepositoryConfig is the configuration for a given repository.
@@ -59,6 +166,16 @@ type RepositoryConfig struct {
 	Topic      string
 	StartIndex int `toml:"start_index"`
 
+	// Secret is the optional secret shared with GitHub to sign webhook
+	// deliveries. When set, live events without a valid signature are
+	// rejected.
+	Secret string
+
+	// Source is the origin of live events for the repository (either
+	// SourceNSQ or SourcePoll). Use the EventSource() function which properly
+	// takes the default into account.
+	Source string
+
 	// events is kept internal: use the EventSetName() function which properly
 	// takes the DefaultEventSet into account.
 	events string `toml:"event_set"`
@@ -72,6 +189,69 @@ func (r RepositoryConfig) EventSetName() string {
 	return r.events
 }
 
+// EventSource returns the origin of live events for the repository.
+func (r RepositoryConfig) EventSource() string {
+	if r.Source == "" {
+		return SourceNSQ
+	}
+	return r.Source
+}
+
+// OrganizationConfig is the configuration for the automatic discovery of the
+// repositories of a GitHub user or organization.
+type OrganizationConfig struct {
+	// Name is the GitHub user or organization name.
+	Name string
+
+	// Include and Exclude are optional lists of glob patterns (in the
+	// path.Match format) that repository names are matched against. When
+	// Include is empty, all repositories are included.
+	Include []string
+	Exclude []string
+
+	// EventSet is the name of subscribed events set for the discovered
+	// repositories.
+	EventSet string `toml:"event_set"`
+
+	// Secret is the optional secret shared with GitHub to sign webhook
+	// deliveries for the discovered repositories.
+	Secret string
+
+	SkipForks    bool `toml:"skip_forks"`
+	SkipArchived bool `toml:"skip_archived"`
+}
+
+// Matches returns whether the repository name matches the include and exclude
+// patterns of the organization.
+func (o OrganizationConfig) Matches(repo string) bool {
+	for _, pattern := range o.Exclude {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return false
+		}
+	}
+	if len(o.Include) == 0 {
+		return true
+	}
+	for _, pattern := range o.Include {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RepositoryConfig returns the configuration for a discovered repository of
+// the organization. Discovered repositories receive their live events from
+// the shared topic.
+func (o OrganizationConfig) RepositoryConfig(user, repo string) RepositoryConfig {
+	return RepositoryConfig{
+		User:   user,
+		Repo:   repo,
+		Secret: o.Secret,
+		events: o.EventSet,
+	}
+}
+
 type SerializedTable map[string]map[string]string
 
 // SerializedConfig is the serialized version of the configuration.
@@ -79,10 +259,17 @@ type SerializedConfig struct {
 	ElasticSearch   string
 	GitHubAPIToken  string `toml:"github_api_token"`
 	PeriodicSync    string `toml:"sync_periodicity"`
+	StateFile       string `toml:"state_file"`
 	NSQ             NSQConfig
+	Cache           CacheConfig
+	Sync            SyncConfig
+	Webhook         WebhookConfig
+	DeadLetter      DeadLetterConfig `toml:"dead_letter"`
+	Deduplication   DeduplicationConfig
 	Functions       map[string]string
 	Mapping         map[string][]string
 	Repositories    map[string]RepositoryConfig
+	Organizations   map[string]OrganizationConfig
 	EventSet        SerializedTable `toml:"event_set"`
 	Transformations SerializedTable
 }
@@ -103,6 +290,7 @@ func (c *SerializedConfig) verify() error {
 	for _, fn := range []func() error{
 		c.verifyEventSet,
 		c.verifyRepositories,
+		c.verifyOrganizations,
 		c.verifyTransformations,
 	} {
 		if err := fn(); err != nil {
@@ -130,15 +318,52 @@ func (c *SerializedConfig) verifyEventSet() error {
 	return nil
 }
 
+// hasSharedSource returns whether live events can be received for repositories
+// without a topic of their own, either from the shared topic or from the
+// webhook receiver.
+func (c *SerializedConfig) hasSharedSource() bool {
+	return c.NSQ.Topic != "" || c.Webhook.Listen != ""
+}
+
 func (c *SerializedConfig) verifyRepositories() error {
+	// The catch-all repository for the shared topic must exist.
+	if c.NSQ.CatchAll != "" {
+		if c.NSQ.Topic == "" {
+			return fmt.Errorf("catch-all repository %q requires a shared topic", c.NSQ.CatchAll)
+		}
+		if _, ok := c.Repositories[c.NSQ.CatchAll]; !ok {
+			return fmt.Errorf("unknown catch-all repository %q", c.NSQ.CatchAll)
+		}
+	} else if c.NSQ.CatchAllSecret != "" {
+		return fmt.Errorf("catch-all secret requires a catch-all repository")
+	}
+
 	topics := make(map[string]struct{})
+	if c.NSQ.Topic != "" {
+		topics[c.NSQ.Topic] = struct{}{}
+	}
 	for repo, conf := range c.Repositories {
 		// Validate event set.
 		eventSetName := conf.EventSetName()
 		if _, ok := c.EventSet[eventSetName]; !ok {
 			return fmt.Errorf("unknown event set %q for repository %q", eventSetName, repo)
 		}
-		// Validate queue name.
+		// Validate events source.
+		switch conf.EventSource() {
+		case SourcePoll:
+			continue
+		case SourceNSQ:
+		default:
+			return fmt.Errorf("unknown source %q for repository %q", conf.Source, repo)
+		}
+		// Validate queue name: repositories without a topic receive their
+		// events from the shared topic, or from the webhook receiver.
+		if conf.Topic == "" {
+			if !c.hasSharedSource() {
+				return fmt.Errorf("no topic for repository %q and no shared topic or webhook receiver", repo)
+			}
+			continue
+		}
 		if _, ok := topics[conf.Topic]; ok {
 			return fmt.Errorf("duplicated topic name %q for repository %q", conf.Topic, repo)
 		}
@@ -147,6 +372,35 @@ func (c *SerializedConfig) verifyRepositories() error {
 	return nil
 }
 
+func (c *SerializedConfig) verifyOrganizations() error {
+	// Discovered repositories receive their live events from the shared
+	// topic, or from the webhook receiver.
+	if len(c.Organizations) != 0 && !c.hasSharedSource() {
+		return fmt.Errorf("organizations require a shared topic or a webhook receiver")
+	}
+	for name, conf := range c.Organizations {
+		if conf.Name == "" {
+			return fmt.Errorf("missing name for organization %q", name)
+		}
+		// Validate event set.
+		eventSetName := conf.EventSet
+		if eventSetName == "" {
+			eventSetName = DefaultEventSet
+		}
+		if _, ok := c.EventSet[eventSetName]; !ok {
+			return fmt.Errorf("unknown event set %q for organization %q", eventSetName, name)
+		}
+		// Validate patterns: matching against the empty string is enough to
+		// detect malformed patterns.
+		for _, pattern := range append(conf.Include, conf.Exclude...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q for organization %q", pattern, name)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *SerializedConfig) verifyTransformations() error {
 	// Transformations should have either none or both of the snapshot
 	// metadata fields.
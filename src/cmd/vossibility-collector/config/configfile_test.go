@@ -47,3 +47,168 @@ snapshot_issue = "test"
 		t.Fatalf("expected %q error, got %v", expected, err)
 	}
 }
+
+func TestConfigVerifyRepositories(t *testing.T) {
+	c := `
+[repositories.repo1]
+topic = "topic"
+
+[repositories.repo2]
+topic = "topic"
+source = "poll"
+
+[repositories.repo3]
+source = "poll"
+
+[event_set.default]
+`
+
+	var config SerializedConfig
+	if _, err := toml.Decode(c, &config); err != nil {
+		t.Fatalf("error parsing configuration: %v", err)
+	}
+	if err := config.verifyRepositories(); err != nil {
+		t.Fatalf("unexpected error verifying repositories: %v", err)
+	}
+
+	config.Repositories["repo4"] = RepositoryConfig{Topic: "topic"}
+	err := config.verifyRepositories()
+	if expected := "duplicated topic name"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+
+	config.Repositories["repo4"] = RepositoryConfig{Source: "invalid"}
+	err = config.verifyRepositories()
+	if expected := "unknown source"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+}
+
+func TestConfigVerifySharedTopic(t *testing.T) {
+	c := `
+[nsq]
+topic = "shared"
+catch_all = "repo1"
+
+[repositories.repo1]
+
+[repositories.repo2]
+topic = "topic"
+
+[event_set.default]
+`
+
+	var config SerializedConfig
+	if _, err := toml.Decode(c, &config); err != nil {
+		t.Fatalf("error parsing configuration: %v", err)
+	}
+	if err := config.verifyRepositories(); err != nil {
+		t.Fatalf("unexpected error verifying repositories: %v", err)
+	}
+
+	config.Repositories["repo3"] = RepositoryConfig{Topic: "shared"}
+	err := config.verifyRepositories()
+	if expected := "duplicated topic name"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+	delete(config.Repositories, "repo3")
+
+	config.NSQ.CatchAll = "unknown"
+	err = config.verifyRepositories()
+	if expected := "unknown catch-all repository"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+
+	config.NSQ.CatchAll, config.NSQ.CatchAllSecret = "", "secret"
+	err = config.verifyRepositories()
+	if expected := "catch-all secret requires a catch-all repository"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+
+	config.NSQ = NSQConfig{}
+	err = config.verifyRepositories()
+	if expected := "no shared topic"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+
+	// Repositories without a topic can receive their events from the
+	// webhook receiver only.
+	config.Webhook.Listen = ":8080"
+	if err := config.verifyRepositories(); err != nil {
+		t.Fatalf("unexpected error verifying repositories with a webhook receiver: %v", err)
+	}
+}
+
+func TestOrganizationMatches(t *testing.T) {
+	o := OrganizationConfig{
+		Include: []string{"docker*", "compose"},
+		Exclude: []string{"*-archive"},
+	}
+	for repo, expected := range map[string]bool{
+		"docker":         true,
+		"docker-py":      true,
+		"compose":        true,
+		"docker-archive": false,
+		"machine":        false,
+	} {
+		if v := o.Matches(repo); v != expected {
+			t.Fatalf("got %v for repository %q, expected %v", v, repo, expected)
+		}
+	}
+
+	o.Include = nil
+	if !o.Matches("machine") {
+		t.Fatal("empty include list should match all repositories")
+	}
+}
+
+func TestConfigVerifyOrganizations(t *testing.T) {
+	c := `
+[nsq]
+topic = "hooks"
+
+[organizations.docker]
+name = "docker"
+include = ["docker*"]
+skip_forks = true
+
+[event_set.default]
+`
+
+	var config SerializedConfig
+	if _, err := toml.Decode(c, &config); err != nil {
+		t.Fatalf("error parsing configuration: %v", err)
+	}
+	if err := config.verifyOrganizations(); err != nil {
+		t.Fatalf("unexpected error verifying organizations: %v", err)
+	}
+	if o := config.Organizations["docker"]; !o.SkipForks || len(o.Include) != 1 {
+		t.Fatalf("unexpected organization configuration %#v", o)
+	}
+
+	o := config.Organizations["docker"]
+	o.Exclude = []string{"["}
+	config.Organizations["docker"] = o
+	err := config.verifyOrganizations()
+	if expected := "invalid pattern"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+
+	config.Organizations["docker"] = OrganizationConfig{Name: "docker", EventSet: "unknown"}
+	err = config.verifyOrganizations()
+	if expected := "unknown event set"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+
+	// Discovered repositories need a source of live events.
+	config.Organizations["docker"] = OrganizationConfig{Name: "docker"}
+	config.NSQ.Topic = ""
+	err = config.verifyOrganizations()
+	if expected := "require a shared topic"; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("expected %q error, got %v", expected, err)
+	}
+	config.Webhook.Listen = ":8080"
+	if err := config.verifyOrganizations(); err != nil {
+		t.Fatalf("unexpected error verifying organizations with a webhook receiver: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"cmd/vossibility-collector/deadletter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+var deadLetterCommand = cli.Command{
+	Name:  "deadletter",
+	Usage: "inspect and process again the live events which failed processing",
+	Subcommands: []cli.Command{
+		{
+			Name:   "list",
+			Usage:  "list the events in the dead letter spool",
+			Action: doDeadLetterList,
+		},
+		{
+			Name:   "show",
+			Usage:  "show the content of the specified events",
+			Action: doDeadLetterShow,
+		},
+		{
+			Name:   "retry",
+			Usage:  "process again the specified events (defaults to all)",
+			Action: doDeadLetterRetry,
+		},
+		{
+			Name:   "purge",
+			Usage:  "remove the specified events from the spool",
+			Action: doDeadLetterPurge,
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "all", Usage: "remove all events"},
+			},
+		},
+	},
+}
+
+// openDeadLetterSpool returns the dead letter spool from the configuration.
+func openDeadLetterSpool(config *Config) *deadletter.Spool {
+	if config.DeadLetter.Path == "" {
+		log.Fatal("no dead letter spool configured")
+	}
+	spool, err := deadletter.NewSpool(config.DeadLetter.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return spool
+}
+
+// selectRecords returns the records for the specified ids, or all records when
+// none is specified.
+func selectRecords(spool *deadletter.Spool, ids []string) []*deadletter.Record {
+	if len(ids) == 0 {
+		records, err := spool.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return records
+	}
+
+	records := make([]*deadletter.Record, 0, len(ids))
+	for _, id := range ids {
+		r, err := spool.Get(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func doDeadLetterList(c *cli.Context) {
+	config := ParseConfigOrDie(c.GlobalString("config"))
+	records := selectRecords(openDeadLetterSpool(config), nil)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tREPOSITORY\tEVENT\tDELIVERY\tATTEMPTS\tFAILED AT\tERROR")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", r.ID, r.Repository, r.Event, r.Delivery, r.Attempts, r.FailedAt.Format(time.RFC3339), firstLine(r.Error))
+	}
+	w.Flush()
+}
+
+func doDeadLetterShow(c *cli.Context) {
+	config := ParseConfigOrDie(c.GlobalString("config"))
+	if len(c.Args()) == 0 {
+		log.Fatal("no event specified")
+	}
+	for _, r := range selectRecords(openDeadLetterSpool(config), c.Args()) {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+	}
+}
+
+// doDeadLetterRetry sends the specified events through the live pipeline once
+// again, with the same deduplication as live events. Events which are
+// successfully processed (or were ingested in the meantime) are removed from
+// the spool, others are kept and updated with the new error.
+func doDeadLetterRetry(c *cli.Context) {
+	config := ParseConfigOrDie(c.GlobalString("config"))
+	client := NewGitHubClient(config)
+	discoverRepositoriesOrDie(client, config)
+	spool := openDeadLetterSpool(config)
+
+	// The records are already in the spool: failures only update them.
+	lock := sync.RWMutex{}
+	opts := newLiveOptionsOrDie(config)
+	handlers := make(map[string]*MessageHandler)
+	for name, repo := range config.Repositories {
+		handlers[name] = NewMessageHandler(client, repo, &lock, opts)
+	}
+
+	var succeeded, failed int
+	for _, r := range selectRecords(spool, c.Args()) {
+		handler, ok := handlers[r.Repository]
+		if !ok {
+			log.Errorf("skipping %q: unknown repository %q", r.ID, r.Repository)
+			failed++
+			continue
+		}
+
+		if err := handler.HandleDelivery(r.Timestamp.UnixNano(), r.Event, r.Delivery, r.Payload); err != nil {
+			log.Errorf("retrying %q: %v", r.ID, err)
+			r.Attempts++
+			r.Error = err.Error()
+			if err := spool.Write(r); err != nil {
+				log.Errorf("updating %q: %v", r.ID, err)
+			}
+			failed++
+			continue
+		}
+
+		if err := spool.Remove(r.ID); err != nil {
+			log.Errorf("removing %q: %v", r.ID, err)
+		}
+		succeeded++
+	}
+	log.Warnf("retry done: %d succeeded, %d failed", succeeded, failed)
+}
+
+func doDeadLetterPurge(c *cli.Context) {
+	config := ParseConfigOrDie(c.GlobalString("config"))
+	if len(c.Args()) == 0 && !c.Bool("all") {
+		log.Fatal("no event specified (use --all to remove all events)")
+	}
+	spool := openDeadLetterSpool(config)
+	for _, r := range selectRecords(spool, c.Args()) {
+		if err := spool.Remove(r.ID); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// firstLine returns the first line of a potentially multiline string.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
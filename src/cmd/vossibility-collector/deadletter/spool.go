@@ -0,0 +1,147 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// recordExtension is the file extension for records in the spool directory.
+const recordExtension = ".json"
+
+// invalidIDChars matches the characters we don't allow in a record ID, which
+// is also used as a file name.
+var invalidIDChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// Record is a live event which failed to be processed, along with the
+// information necessary to process it again.
+type Record struct {
+	// ID uniquely identifies the record in the spool.
+	ID string `json:"id"`
+
+	// Repository is the given name of the repository the event relates to.
+	Repository string `json:"repository"`
+
+	// Event is the GitHub event type.
+	Event string `json:"event"`
+
+	// Delivery is the GitHub delivery identifier.
+	Delivery string `json:"delivery"`
+
+	// Error is the error returned by the last processing attempt.
+	Error string `json:"error"`
+
+	// Attempts is the number of processing attempts.
+	Attempts int `json:"attempts"`
+
+	// Timestamp is the time at which the event was initially received.
+	Timestamp time.Time `json:"timestamp"`
+
+	// FailedAt is the time at which the event was sent to the spool.
+	FailedAt time.Time `json:"failed_at"`
+
+	// Payload is the raw GitHub payload.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Spool is an on-disk collection of records, each of them stored as a single
+// JSON file in the spool directory.
+type Spool struct {
+	path string
+}
+
+// NewSpool returns a Spool backed by the specified directory, which is created
+// if necessary.
+func NewSpool(path string) (*Spool, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &Spool{path: path}, nil
+}
+
+// Write adds the record to the spool, assigning it an ID if it doesn't have
+// one already.
+func (s *Spool) Write(r *Record) error {
+	if r.FailedAt.IsZero() {
+		r.FailedAt = time.Now()
+	}
+	if r.ID == "" {
+		// Prefixing with the failure time makes records sort chronologically.
+		r.ID = fmt.Sprintf("%d-%s", r.FailedAt.UnixNano(), invalidIDChars.ReplaceAllString(r.Delivery, "_"))
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a partially written record is
+	// never visible in the spool.
+	tmp := s.filename(r.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filename(r.ID))
+}
+
+// List returns all records in the spool, oldest first.
+func (s *Spool) List() ([]*Record, error) {
+	infos, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Record
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != recordExtension {
+			continue
+		}
+		r, err := s.Get(strings.TrimSuffix(info.Name(), recordExtension))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// Get returns the record with the specified ID.
+func (s *Spool) Get(id string) (*Record, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(s.filename(id))
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("reading record %q: %v", id, err)
+	}
+	return &r, nil
+}
+
+// Remove deletes the record with the specified ID from the spool.
+func (s *Spool) Remove(id string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	return os.Remove(s.filename(id))
+}
+
+func (s *Spool) filename(id string) string {
+	return filepath.Join(s.path, id+recordExtension)
+}
+
+// checkID protects from IDs given by the user which would escape the spool
+// directory.
+func checkID(id string) error {
+	if id == "" || invalidIDChars.MatchString(id) {
+		return fmt.Errorf("invalid record id %q", id)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package deadletter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSpool(t *testing.T) (*Spool, func()) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	s, err := NewSpool(filepath.Join(dir, "spool"))
+	if err != nil {
+		t.Fatalf("failed to create spool: %v", err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestSpoolWriteAndGet(t *testing.T) {
+	s, cleanup := testSpool(t)
+	defer cleanup()
+
+	r := &Record{
+		Repository: "testrepo",
+		Event:      "pull_request",
+		Delivery:   "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+		Error:      "mapping conflict",
+		Attempts:   5,
+		Timestamp:  time.Date(2015, 10, 21, 16, 29, 0, 0, time.UTC),
+		Payload:    []byte(`{"action":"opened"}`),
+	}
+	if err := s.Write(r); err != nil {
+		t.Fatalf("failed to write record: %v", err)
+	}
+	if r.ID == "" {
+		t.Fatal("no ID assigned to written record")
+	}
+
+	g, err := s.Get(r.ID)
+	if err != nil {
+		t.Fatalf("failed to get record %q: %v", r.ID, err)
+	}
+	if g.Delivery != r.Delivery || g.Repository != r.Repository || g.Event != r.Event || g.Attempts != r.Attempts {
+		t.Fatalf("unexpected record %#v (expected %#v)", g, r)
+	}
+	if !g.Timestamp.Equal(r.Timestamp) {
+		t.Fatalf("unexpected timestamp %v (expected %v)", g.Timestamp, r.Timestamp)
+	}
+	if string(g.Payload) != string(r.Payload) {
+		t.Fatalf("unexpected payload %s (expected %s)", g.Payload, r.Payload)
+	}
+}
+
+func TestSpoolListAndRemove(t *testing.T) {
+	s, cleanup := testSpool(t)
+	defer cleanup()
+
+	now := time.Now()
+	for i, delivery := range []string{"first", "second", "../third"} {
+		if err := s.Write(&Record{Delivery: delivery, FailedAt: now.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+	}
+
+	l, err := s.List()
+	if err != nil {
+		t.Fatalf("failed to list records: %v", err)
+	}
+	if len(l) != 3 {
+		t.Fatalf("listed %d records, expected 3", len(l))
+	}
+	for i, expected := range []string{"first", "second", "../third"} {
+		if l[i].Delivery != expected {
+			t.Fatalf("unexpected record %d delivery %q (expected %q)", i, l[i].Delivery, expected)
+		}
+	}
+
+	if err := s.Remove(l[0].ID); err != nil {
+		t.Fatalf("failed to remove record: %v", err)
+	}
+	if _, err := s.Get(l[0].ID); err == nil {
+		t.Fatal("expected error getting a removed record")
+	}
+	if l, err = s.List(); err != nil || len(l) != 2 {
+		t.Fatalf("listed %d records after removal (err=%v), expected 2", len(l), err)
+	}
+}
+
+func TestSpoolInvalidID(t *testing.T) {
+	s, cleanup := testSpool(t)
+	defer cleanup()
+
+	for _, id := range []string{"", "../record", "a/b"} {
+		if _, err := s.Get(id); err == nil {
+			t.Fatalf("expected error getting record %q", id)
+		}
+		if err := s.Remove(id); err == nil {
+			t.Fatalf("expected error removing record %q", id)
+		}
+	}
+}
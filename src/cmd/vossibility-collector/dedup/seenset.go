@@ -0,0 +1,316 @@
+package dedup
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SeenSet is a bounded set of identifiers which expire after a given duration.
+// It is used to detect duplicated deliveries of the same live event.
+//
+// A SeenSet can optionally be backed by a file in order to survive restarts:
+// identifiers are appended to the file as they are added, and the file gets
+// compacted when it grows too large compared to the set itself. The file can
+// be shared by several processes: it is modified under an exclusive lock, and
+// compaction keeps the identifiers added by the other processes.
+type SeenSet struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // Oldest entries first.
+
+	// pending holds the identifiers being processed, which were reserved by
+	// TryAdd but not yet added or released.
+	pending map[string]struct{}
+
+	path     string
+	file     *os.File
+	appended int
+
+	// now is the time source, which can be overriden for testing purposes.
+	now func() time.Time
+}
+
+// entry is a single element of the SeenSet.
+type entry struct {
+	id   string
+	seen time.Time
+}
+
+// New creates an in-memory SeenSet holding at most size identifiers, each of
+// them for the ttl duration.
+func New(size int, ttl time.Duration) *SeenSet {
+	return &SeenSet{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		pending: make(map[string]struct{}),
+		now:     time.Now,
+	}
+}
+
+// Open creates a SeenSet backed by the specified file, loading any previously
+// stored identifiers that haven't expired yet.
+func Open(path string, size int, ttl time.Duration) (*SeenSet, error) {
+	s := New(size, ttl)
+	s.path = path
+	lock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock(lock)
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Contains returns whether the identifier was added to the set and hasn't
+// expired yet.
+func (s *SeenSet) Contains(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	_, ok := s.entries[id]
+	return ok
+}
+
+// TryAdd reserves the identifier while it is being processed. It returns false
+// if the identifier is in the set or already reserved, which makes checking
+// and reserving it atomic. The reservation ends with Add once processed, or
+// with Release if the processing failed.
+func (s *SeenSet) TryAdd(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	if _, ok := s.entries[id]; ok {
+		return false
+	}
+	if _, ok := s.pending[id]; ok {
+		return false
+	}
+	s.pending[id] = struct{}{}
+	return true
+}
+
+// Release ends the reservation of the identifier without adding it, so that it
+// can be processed again.
+func (s *SeenSet) Release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+}
+
+// Add inserts the identifier into the set, evicting the oldest identifier if
+// the set is full. It ends its reservation, if any.
+func (s *SeenSet) Add(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	delete(s.pending, id)
+	s.insert(id, now)
+	s.expire()
+	if s.file == nil {
+		return nil
+	}
+
+	lock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock(lock)
+	if err := s.reopen(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.file, "%d %s\n", now.UnixNano(), id); err != nil {
+		return err
+	}
+	if s.appended++; s.appended > 2*s.size {
+		return s.compact()
+	}
+	return nil
+}
+
+// Len returns the number of identifiers in the set.
+func (s *SeenSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	return s.order.Len()
+}
+
+// Close releases the backing file, if any.
+func (s *SeenSet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *SeenSet) insert(id string, seen time.Time) {
+	if e, ok := s.entries[id]; ok {
+		s.order.Remove(e)
+	}
+	s.entries[id] = s.order.PushBack(&entry{id: id, seen: seen})
+	for s.order.Len() > s.size {
+		s.remove(s.order.Front())
+	}
+}
+
+func (s *SeenSet) remove(e *list.Element) {
+	delete(s.entries, e.Value.(*entry).id)
+	s.order.Remove(e)
+}
+
+// expire removes the expired entries, which are necessarily at the front of
+// the list as identifiers are inserted in chronological order.
+func (s *SeenSet) expire() {
+	limit := s.now().Add(-s.ttl)
+	for e := s.order.Front(); e != nil && e.Value.(*entry).seen.Before(limit); e = s.order.Front() {
+		s.remove(e)
+	}
+}
+
+// lock takes the exclusive lock of the backing file. The file itself is
+// replaced on compaction, hence the separate lock file.
+func (s *SeenSet) lock() (*os.File, error) {
+	f, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func unlock(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+// reopen opens the backing file again if another process replaced it while
+// compacting. It must be called with the lock of the file held.
+func (s *SeenSet) reopen() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if cur, err := s.file.Stat(); err == nil && os.SameFile(fi, cur) {
+		return nil
+	}
+	s.file.Close()
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// load merges the identifiers from the backing file into the set, which keeps
+// the identifiers added by other processes sharing the file.
+func (s *SeenSet) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue // Ignore truncated lines.
+		}
+		nanos, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		seen := time.Unix(0, nanos)
+		if e, ok := s.entries[parts[1]]; !ok {
+			s.entries[parts[1]] = s.order.PushBack(&entry{id: parts[1], seen: seen})
+		} else if ent := e.Value.(*entry); ent.seen.Before(seen) {
+			ent.seen = seen
+		}
+	}
+
+	// Identifiers of the different processes are interleaved: restore the
+	// chronological order before enforcing the bounds.
+	var ents []*entry
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		ents = append(ents, e.Value.(*entry))
+	}
+	sort.Stable(bySeen(ents))
+	s.entries = make(map[string]*list.Element)
+	s.order.Init()
+	for _, ent := range ents {
+		s.entries[ent.id] = s.order.PushBack(ent)
+	}
+	for s.order.Len() > s.size {
+		s.remove(s.order.Front())
+	}
+	s.expire()
+	return scanner.Err()
+}
+
+// bySeen sorts entries by increasing time.
+type bySeen []*entry
+
+func (b bySeen) Len() int           { return len(b) }
+func (b bySeen) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bySeen) Less(i, j int) bool { return b[i].seen.Before(b[j].seen) }
+
+// compact merges the backing file into the set, rewrites it with the content
+// of the set, and reopens it for appending. It must be called with the lock of
+// the file held.
+func (s *SeenSet) compact() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		ent := e.Value.(*entry)
+		fmt.Fprintf(w, "%d %s\n", ent.seen.UnixNano(), ent.id)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	s.appended = 0
+	return nil
+}
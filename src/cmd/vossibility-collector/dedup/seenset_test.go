@@ -0,0 +1,163 @@
+package dedup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.t
+}
+
+func TestSeenSetContains(t *testing.T) {
+	s := New(10, time.Hour)
+	if s.Contains("id") {
+		t.Fatal("unexpected identifier in empty set")
+	}
+	if err := s.Add("id"); err != nil {
+		t.Fatalf("failed to add identifier: %v", err)
+	}
+	if !s.Contains("id") {
+		t.Fatal("missing identifier after add")
+	}
+}
+
+func TestSeenSetBounded(t *testing.T) {
+	s := New(2, time.Hour)
+	for _, id := range []string{"id1", "id2", "id3"} {
+		s.Add(id)
+	}
+	if s.Len() != 2 {
+		t.Fatalf("unexpected set length %d, expected 2", s.Len())
+	}
+	if s.Contains("id1") {
+		t.Fatal("oldest identifier wasn't evicted")
+	}
+	if !s.Contains("id2") || !s.Contains("id3") {
+		t.Fatal("missing recent identifiers")
+	}
+}
+
+func TestSeenSetExpiration(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	s := New(10, time.Hour)
+	s.now = clock.Now
+
+	s.Add("id1")
+	clock.t = clock.t.Add(30 * time.Minute)
+	s.Add("id2")
+
+	clock.t = clock.t.Add(45 * time.Minute)
+	if s.Contains("id1") {
+		t.Fatal("identifier didn't expire")
+	}
+	if !s.Contains("id2") {
+		t.Fatal("identifier expired too soon")
+	}
+}
+
+func TestSeenSetPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen")
+
+	s, err := Open(path, 3, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open set: %v", err)
+	}
+	for _, id := range []string{"id1", "id2", "id3", "id4", "id5", "id6", "id7", "id8"} {
+		if err := s.Add(id); err != nil {
+			t.Fatalf("failed to add identifier: %v", err)
+		}
+	}
+	s.Close()
+
+	if s, err = Open(path, 3, time.Hour); err != nil {
+		t.Fatalf("failed to reopen set: %v", err)
+	}
+	defer s.Close()
+	if s.Len() != 3 {
+		t.Fatalf("unexpected set length %d after reopening, expected 3", s.Len())
+	}
+	for _, id := range []string{"id6", "id7", "id8"} {
+		if !s.Contains(id) {
+			t.Fatalf("missing identifier %q after reopening", id)
+		}
+	}
+}
+
+func TestSeenSetTryAdd(t *testing.T) {
+	s := New(10, time.Hour)
+	if !s.TryAdd("id") {
+		t.Fatal("failed to reserve identifier")
+	}
+	if s.TryAdd("id") {
+		t.Fatal("reserved identifier twice")
+	}
+	s.Release("id")
+	if !s.TryAdd("id") {
+		t.Fatal("failed to reserve released identifier")
+	}
+	if err := s.Add("id"); err != nil {
+		t.Fatalf("failed to add identifier: %v", err)
+	}
+	if s.TryAdd("id") {
+		t.Fatal("reserved identifier of the set")
+	}
+}
+
+func TestSeenSetShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen")
+
+	s1, err := Open(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open set: %v", err)
+	}
+	defer s1.Close()
+	s2, err := Open(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open set: %v", err)
+	}
+	defer s2.Close()
+
+	// Compacting the first set must neither lose the identifiers of the
+	// second one, nor prevent it from appending to the new file.
+	s2.Add("id1")
+	s1.Add("id2")
+	lock, err := s1.lock()
+	if err != nil {
+		t.Fatalf("failed to lock set: %v", err)
+	}
+	err = s1.compact()
+	unlock(lock)
+	if err != nil {
+		t.Fatalf("failed to compact set: %v", err)
+	}
+	s2.Add("id3")
+
+	s, err := Open(path, 10, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to reopen set: %v", err)
+	}
+	defer s.Close()
+	for _, id := range []string{"id1", "id2", "id3"} {
+		if !s.Contains(id) {
+			t.Fatalf("missing identifier %q after reopening", id)
+		}
+	}
+}
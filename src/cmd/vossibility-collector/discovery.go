@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+
+	"cmd/vossibility-collector/github"
+	"cmd/vossibility-collector/storage"
+
+	log "github.com/Sirupsen/logrus"
+	gh "github.com/google/go-github/github"
+)
+
+// discoveredName returns the given name for a repository discovered as part
+// of an organization. Given names are used as Elastic Search index prefixes,
+// which must be lowercase.
+func discoveredName(org, repo string) string {
+	return strings.ToLower(org + "-" + repo)
+}
+
+// discoverRepositories lists the repositories of the configured organizations
+// and updates the configured repositories accordingly: new repositories are
+// added, and those which disappeared or no longer match are removed.
+// Repositories explicitly configured always take precedence.
+//
+// The repositories of an organization which can't be listed are left
+// untouched, and the last error is returned.
+func discoverRepositories(client *gh.Client, c *Config) error {
+	if len(c.Organizations) == 0 {
+		return nil
+	}
+
+	// Index the explicitly configured repositories by their full name.
+	explicit := make(map[string]struct{})
+	for name, r := range c.Repositories {
+		if _, ok := c.discovered[name]; !ok {
+			explicit[strings.ToLower(r.FullName())] = struct{}{}
+		}
+	}
+
+	var lastErr error
+	for orgName, org := range c.Organizations {
+		repos, err := github.ListOwnerRepositories(client, org.Name)
+		if err != nil {
+			log.Errorf("discovering repositories for organization %q: %v", orgName, err)
+			lastErr = err
+			continue
+		}
+
+		seen := make(map[string]struct{})
+		for _, r := range repos {
+			if (org.SkipForks && r.Fork) || (org.SkipArchived && r.Archived) || !org.Matches(r.Name) {
+				continue
+			}
+			if _, ok := explicit[strings.ToLower(r.FullName)]; ok {
+				continue
+			}
+
+			givenName := discoveredName(orgName, r.Name)
+			if o, ok := c.discovered[givenName]; ok && o == orgName {
+				seen[givenName] = struct{}{}
+				continue
+			} else if _, ok := c.Repositories[givenName]; ok {
+				log.Warnf("ignoring discovered repository %s: given name %q is already in use", r.FullName, givenName)
+				continue
+			}
+
+			conf := org.RepositoryConfig(r.Owner.Login, r.Name)
+			repo, err := storage.NewRepository(givenName, &conf, c.serialized)
+			if err != nil {
+				log.Errorf("creating discovered repository %s: %v", r.FullName, err)
+				lastErr = err
+				continue
+			}
+			repo.PeriodicSync = c.PeriodicSync
+			c.Repositories[givenName] = repo
+			c.discovered[givenName] = orgName
+			seen[givenName] = struct{}{}
+			log.Infof("discovered repository %s", repo.PrettyName())
+		}
+
+		// Forget about the repositories which are gone.
+		for givenName, o := range c.discovered {
+			if _, ok := seen[givenName]; !ok && o == orgName {
+				log.Infof("repository %s is no longer part of organization %q", c.Repositories[givenName].PrettyName(), orgName)
+				delete(c.Repositories, givenName)
+				delete(c.discovered, givenName)
+			}
+		}
+	}
+	return lastErr
+}
+
+// discoverRepositoriesOrDie discovers the repositories of the configured
+// organizations, and exits in case of error.
+func discoverRepositoriesOrDie(client *gh.Client, c *Config) {
+	if err := discoverRepositories(client, c); err != nil {
+		log.Fatalf("failed to discover repositories: %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package github
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// cacheEntrySuffix is the file name suffix of cached responses.
+	cacheEntrySuffix = ".resp"
+
+	// cacheStatsFile is the name of the file where cache statistics are
+	// persisted.
+	cacheStatsFile = "stats.json"
+
+	// cacheStatsInterval is the minimum interval between two saves of the
+	// cache statistics.
+	cacheStatsInterval = 30 * time.Second
+)
+
+// caches are the responses caches created by the process, which statistics
+// are saved by FlushCacheStats.
+var caches struct {
+	sync.Mutex
+	list []*cache
+}
+
+// FlushCacheStats saves the statistics of the responses caches created by the
+// process which aren't saved yet. It is meant to be called before exiting.
+func FlushCacheStats() {
+	caches.Lock()
+	defer caches.Unlock()
+	for _, c := range caches.list {
+		c.mu.Lock()
+		if err := c.save(); err != nil {
+			log.Warnf("failed to save cache statistics: %v", err)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// CacheStats are the statistics of the responses cache.
+type CacheStats struct {
+	// Hits is the number of requests answered with "not modified", and served
+	// from the cache.
+	Hits int64 `json:"hits"`
+
+	// Misses is the number of requests for which the full response was
+	// retrieved.
+	Misses int64 `json:"misses"`
+
+	// Entries and Size are the number of cached responses and their total
+	// size in bytes.
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+}
+
+// ReadCacheStats returns the statistics persisted in the cache directory.
+func ReadCacheStats(dir string) (CacheStats, error) {
+	var stats CacheStats
+	b, err := ioutil.ReadFile(filepath.Join(dir, cacheStatsFile))
+	if os.IsNotExist(err) {
+		return stats, nil
+	} else if err != nil {
+		return stats, err
+	}
+	return stats, json.Unmarshal(b, &stats)
+}
+
+// cache is an http.RoundTripper which stores GitHub API responses along with
+// their ETag or Last-Modified header in a directory, and turns subsequent
+// requests for the same resources into conditional requests. Responses which
+// weren't modified don't count against the rate limit, and are served from
+// the cache.
+//
+// When the total size of cached responses exceeds the limit, the least
+// recently used ones are evicted.
+//
+// The statistics are shared by all processes using the same directory: each
+// cache counts its hits and misses in memory, and adds them to the persisted
+// statistics from time to time.
+type cache struct {
+	base    http.RoundTripper
+	dir     string
+	maxSize int64
+
+	// stats holds the hits and misses since the statistics were last saved
+	// at time saved, and the entries and size of the cache.
+	mu    sync.Mutex
+	stats CacheStats
+	saved time.Time
+}
+
+// newCache creates a cache storing responses in the specified directory. A
+// maxSize of zero means no limit.
+func newCache(base http.RoundTripper, dir string, maxSize int64) (*cache, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &cache{
+		base:    base,
+		dir:     dir,
+		maxSize: maxSize,
+		saved:   time.Now(),
+	}
+	if err := c.count(); err != nil {
+		return nil, err
+	}
+
+	caches.Lock()
+	caches.list = append(caches.list, c)
+	caches.Unlock()
+	return c, nil
+}
+
+// count computes the number and total size of the cached responses, which
+// other processes sharing the directory may have changed.
+func (c *cache) count() error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	c.stats.Entries, c.stats.Size = len(entries), 0
+	for _, e := range entries {
+		c.stats.Size += e.Size()
+	}
+	return nil
+}
+
+// key returns the cache key for the request. The authorization header is part
+// of the key, as responses depend on who is asking.
+func (c *cache) key(req *http.Request) string {
+	h := sha256.New()
+	for _, s := range []string{req.URL.String(), req.Header.Get("Accept"), req.Header.Get("Authorization")} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *cache) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Only plain GET requests are cached: requests which are already
+	// conditional are the business of the caller.
+	if req.Method != "GET" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return c.base.RoundTrip(req)
+	}
+
+	path := filepath.Join(c.dir, c.key(req)+cacheEntrySuffix)
+	cached := c.load(path, req)
+	if cached != nil {
+		// The request is modified, so we need our own copy.
+		r := new(http.Request)
+		*r = *req
+		r.Header = make(http.Header)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			r.Header.Set("If-Modified-Since", lastModified)
+		}
+		req = r
+	}
+
+	resp, err := c.base.RoundTrip(req)
+	if err != nil {
+		if cached != nil {
+			cached.Body.Close()
+		}
+		return nil, err
+	}
+
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		// The headers of the fresh response hold the current rate limit
+		// status.
+		resp.Body.Close()
+		for k, v := range resp.Header {
+			cached.Header[k] = v
+		}
+		cached.Request = req
+		c.touch(path)
+		c.record(true, 0, 0)
+		return cached, nil
+	}
+	if cached != nil {
+		cached.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusOK || (resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+	return c.store(path, resp)
+}
+
+// load returns the cached response for the request, or nil if there is none.
+func (c *cache) load(path string, req *http.Request) *http.Response {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+	if err != nil {
+		log.Warnf("ignoring invalid cache entry %q: %v", path, err)
+		return nil
+	}
+	return resp
+}
+
+// store saves the response in the cache, and returns an equivalent response
+// as the original body is consumed in the process.
+func (c *cache) store(path string, resp *http.Response) (*http.Response, error) {
+	b, err := httputil.DumpResponse(resp, true)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	oldSize := int64(-1)
+	if fi, err := os.Stat(path); err == nil {
+		oldSize = fi.Size()
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		log.Warnf("failed to write cache entry: %v", err)
+	} else if err := os.Rename(tmp, path); err != nil {
+		log.Warnf("failed to write cache entry: %v", err)
+	} else {
+		c.record(false, int64(len(b)), oldSize)
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), resp.Request)
+}
+
+// touch marks the cache entry as recently used.
+func (c *cache) touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// record updates the statistics, and saves them when they weren't for long
+// enough. For a cache miss, size is the size of the new entry and oldSize the
+// size of the entry it replaced (or -1).
+func (c *cache) record(hit bool, size, oldSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+		c.stats.Size += size
+		if oldSize >= 0 {
+			c.stats.Size -= oldSize
+		} else {
+			c.stats.Entries++
+		}
+		if c.maxSize > 0 && c.stats.Size > c.maxSize {
+			c.evict()
+		}
+	}
+
+	if time.Since(c.saved) >= cacheStatsInterval {
+		if err := c.save(); err != nil {
+			log.Warnf("failed to save cache statistics: %v", err)
+		}
+	}
+}
+
+// save adds the hits and misses counted since the last save to the persisted
+// statistics, along with the current entries and size of the cache. The file
+// is read again under a lock, so that the counts of the other processes using
+// the cache aren't lost. It must be called with the lock held.
+func (c *cache) save() error {
+	lock, err := os.OpenFile(filepath.Join(c.dir, cacheStatsFile+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	stats, err := ReadCacheStats(c.dir)
+	if err != nil {
+		log.Warnf("ignoring invalid cache statistics: %v", err)
+		stats = CacheStats{}
+	}
+	if err := c.count(); err != nil {
+		return err
+	}
+	stats.Hits += c.stats.Hits
+	stats.Misses += c.stats.Misses
+	stats.Entries, stats.Size = c.stats.Entries, c.stats.Size
+
+	b, err := json.Marshal(&stats)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.dir, cacheStatsFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, cacheStatsFile)); err != nil {
+		return err
+	}
+	c.stats.Hits, c.stats.Misses = 0, 0
+	c.saved = time.Now()
+	return nil
+}
+
+// evict removes the least recently used entries until the cache size is back
+// under the limit. It must be called with the lock held.
+func (c *cache) evict() {
+	entries, err := c.entries()
+	if err != nil {
+		log.Warnf("failed to list cache entries: %v", err)
+		return
+	}
+	sort.Sort(byModTime(entries))
+	for _, e := range entries {
+		if c.stats.Size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil {
+			continue
+		}
+		c.stats.Size -= e.Size()
+		c.stats.Entries--
+	}
+}
+
+// entries lists the cached responses.
+func (c *cache) entries() ([]os.FileInfo, error) {
+	all, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []os.FileInfo
+	for _, fi := range all {
+		if strings.HasSuffix(fi.Name(), cacheEntrySuffix) {
+			entries = append(entries, fi)
+		}
+	}
+	return entries, nil
+}
+
+// byModTime sorts files by increasing modification time.
+type byModTime []os.FileInfo
+
+func (b byModTime) Len() int           { return len(b) }
+func (b byModTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byModTime) Less(i, j int) bool { return b[i].ModTime().Before(b[j].ModTime()) }
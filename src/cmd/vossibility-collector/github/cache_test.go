@@ -0,0 +1,139 @@
+package github
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCacheConditionalRequests(t *testing.T) {
+	var conditional int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := newCache(nil, dir, 0)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	client := &http.Client{Transport: c}
+	for i := 0; i != 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(b) != "content" {
+			t.Fatalf("unexpected response %d %q", resp.StatusCode, b)
+		}
+	}
+	if conditional != 1 {
+		t.Fatalf("unexpected number of conditional requests %d, expected 1", conditional)
+	}
+
+	FlushCacheStats()
+	stats, err := ReadCacheStats(dir)
+	if err != nil {
+		t.Fatalf("failed to read statistics: %v", err)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected statistics %#v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", `"`+req.URL.Path+`"`)
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Only a single response fits in the cache.
+	c, err := newCache(nil, dir, 200)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	client := &http.Client{Transport: c}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if c.stats.Entries != 1 || c.stats.Size > 200 {
+		t.Fatalf("unexpected statistics after eviction %#v", c.stats)
+	}
+	if entries, _ := c.entries(); len(entries) != 1 {
+		t.Fatalf("unexpected number of cache entries %d, expected 1", len(entries))
+	}
+}
+
+func TestCacheSharedStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Two caches share the directory, as for processes running at the same
+	// time: the statistics of both are kept.
+	for i := 0; i != 2; i++ {
+		c, err := newCache(nil, dir, 0)
+		if err != nil {
+			t.Fatalf("failed to create cache: %v", err)
+		}
+		client := &http.Client{Transport: c}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if c.stats.Hits+c.stats.Misses != 1 {
+			t.Fatalf("unexpected statistics before saving %#v", c.stats)
+		}
+	}
+	FlushCacheStats()
+
+	stats, err := ReadCacheStats(dir)
+	if err != nil {
+		t.Fatalf("failed to read statistics: %v", err)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected statistics %#v", stats)
+	}
+}
@@ -0,0 +1,146 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cmd/vossibility-collector/storage"
+
+	"github.com/google/go-github/github"
+)
+
+// CI states summarizing the commit statuses and check runs of a commit.
+const (
+	CIStateSuccess = "success"
+	CIStateFailure = "failure"
+	CIStatePending = "pending"
+)
+
+// ciStatus is the continuous integration status of the head commit of a pull
+// request, which merges the commit statuses and the check runs.
+type ciStatus struct {
+	// State is the overall state, or empty when the commit has neither
+	// statuses nor check runs.
+	State string `json:"state"`
+
+	// Statuses and CheckRuns are the raw objects returned by the API.
+	Statuses  []json.RawMessage `json:"statuses"`
+	CheckRuns []json.RawMessage `json:"check_runs"`
+
+	// StartedAt is the time the first status or check run started at, and
+	// CompletedAt the time the last one completed at (unless some are still
+	// pending). Duration is the time between the two in seconds.
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Duration    float64    `json:"duration,omitempty"`
+}
+
+// commitStatus is the subset of a commit status used for the summary.
+type commitStatus struct {
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// checkRun is the subset of a check run used for the summary.
+type checkRun struct {
+	Status      string     `json:"status"`
+	Conclusion  string     `json:"conclusion"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// fetchCIStatus retrieves the combined status and the check runs of the
+// commit.
+func fetchCIStatus(cli *github.Client, repo *storage.Repository, sha string) (*ciStatus, error) {
+	req, err := cli.NewRequest("GET", fmt.Sprintf("repos/%s/%s/commits/%s/status", repo.User, repo.Repo, sha), nil)
+	if err != nil {
+		return nil, err
+	}
+	var combined struct {
+		Statuses []json.RawMessage `json:"statuses"`
+	}
+	if _, err := cli.Do(req, &combined); err != nil {
+		return nil, err
+	}
+
+	var runs []json.RawMessage
+	path := fmt.Sprintf("repos/%s/%s/commits/%s/check-runs", repo.User, repo.Repo, sha)
+	for page := 1; page != 0; {
+		list, resp, err := listRawField(cli, path, "check_runs", nil, page, DefaultPerPage)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, list...)
+		page = resp.NextPage
+	}
+	return summarizeCIStatus(combined.Statuses, runs)
+}
+
+// summarizeCIStatus computes the overall state and duration of the statuses
+// and check runs. A single failure fails the whole, and anything pending
+// makes the whole pending.
+func summarizeCIStatus(statuses, runs []json.RawMessage) (*ciStatus, error) {
+	ci := &ciStatus{
+		Statuses:  statuses,
+		CheckRuns: runs,
+	}
+	var failed, pending bool
+	observe := func(started, completed *time.Time) {
+		if started != nil && (ci.StartedAt == nil || started.Before(*ci.StartedAt)) {
+			ci.StartedAt = started
+		}
+		if completed != nil && (ci.CompletedAt == nil || completed.After(*ci.CompletedAt)) {
+			ci.CompletedAt = completed
+		}
+	}
+
+	for _, raw := range statuses {
+		var s commitStatus
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		switch s.State {
+		case "pending":
+			pending = true
+		case "failure", "error":
+			failed = true
+		}
+		observe(&s.CreatedAt, &s.UpdatedAt)
+	}
+	for _, raw := range runs {
+		var r checkRun
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, err
+		}
+		if r.Status != "completed" {
+			pending = true
+		}
+		switch r.Conclusion {
+		case "failure", "timed_out", "cancelled", "action_required":
+			failed = true
+		}
+		observe(r.StartedAt, r.CompletedAt)
+	}
+
+	switch {
+	case len(statuses) == 0 && len(runs) == 0:
+		return ci, nil
+	case failed:
+		ci.State = CIStateFailure
+	case pending:
+		ci.State = CIStatePending
+	default:
+		ci.State = CIStateSuccess
+	}
+
+	// The completion time only makes sense once nothing is pending.
+	if pending {
+		ci.CompletedAt = nil
+	}
+	if ci.StartedAt != nil && ci.CompletedAt != nil {
+		ci.Duration = ci.CompletedAt.Sub(*ci.StartedAt).Seconds()
+	}
+	return ci, nil
+}
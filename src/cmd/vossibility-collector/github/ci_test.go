@@ -0,0 +1,52 @@
+package github
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func rawList(objects ...string) []json.RawMessage {
+	var l []json.RawMessage
+	for _, o := range objects {
+		l = append(l, json.RawMessage(o))
+	}
+	return l
+}
+
+func TestSummarizeCIStatus(t *testing.T) {
+	statuses := rawList(`{"state": "success", "created_at": "2016-01-01T10:00:00Z", "updated_at": "2016-01-01T10:05:00Z"}`)
+	for _, c := range []struct {
+		runs     []json.RawMessage
+		state    string
+		duration float64
+	}{
+		{
+			runs:     rawList(`{"status": "completed", "conclusion": "success", "started_at": "2016-01-01T09:59:00Z", "completed_at": "2016-01-01T10:10:00Z"}`),
+			state:    CIStateSuccess,
+			duration: 660,
+		},
+		{
+			runs:     rawList(`{"status": "completed", "conclusion": "timed_out", "started_at": "2016-01-01T10:00:00Z", "completed_at": "2016-01-01T10:10:00Z"}`),
+			state:    CIStateFailure,
+			duration: 600,
+		},
+		{
+			runs:  rawList(`{"status": "in_progress", "started_at": "2016-01-01T10:00:00Z"}`),
+			state: CIStatePending,
+		},
+	} {
+		ci, err := summarizeCIStatus(statuses, c.runs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ci.State != c.state || ci.Duration != c.duration {
+			t.Fatalf("got state %q and duration %v, expected %q and %v", ci.State, ci.Duration, c.state, c.duration)
+		}
+	}
+
+	if ci, err := summarizeCIStatus(nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ci.State != "" {
+		t.Fatalf("unexpected state %q for commit without CI", ci.State)
+	}
+}
@@ -3,17 +3,52 @@ package github
 import (
 	"net/http"
 
+	log "github.com/Sirupsen/logrus"
 	gh "github.com/google/go-github/github"
 	"golang.org/x/oauth2"
 )
 
+// ClientOptions are the options for the creation of a GitHub API client.
+type ClientOptions struct {
+	// Token is the optional GitHub API token.
+	Token string
+
+	// CachePath is the optional directory where API responses are cached.
+	CachePath string
+
+	// CacheMaxSize is the maximum total size in bytes of the cached
+	// responses, or zero for no limit.
+	CacheMaxSize int64
+}
+
+// NewClient creates a GitHub API client authenticated with the token, if any.
+// Requests are scheduled according to the GitHub rate limit.
 func NewClient(token string) *gh.Client {
-	var tc *http.Client
-	if token != "" {
+	return NewClientWithOptions(&ClientOptions{Token: token})
+}
+
+// NewClientWithOptions creates a GitHub API client with the specific options
+// set. Requests are scheduled according to the GitHub rate limit.
+func NewClientWithOptions(opts *ClientOptions) *gh.Client {
+	transport := http.DefaultTransport
+	if opts.CachePath != "" {
+		if c, err := newCache(transport, opts.CachePath, opts.CacheMaxSize); err != nil {
+			log.Errorf("disabling GitHub API responses cache: %v", err)
+		} else {
+			transport = c
+		}
+	}
+
+	// The authorization must be set before the request reaches the cache, as
+	// it is part of the cache key.
+	if opts.Token != "" {
 		ts := oauth2.StaticTokenSource(&oauth2.Token{
-			AccessToken: token,
+			AccessToken: opts.Token,
 		})
-		tc = oauth2.NewClient(oauth2.NoContext, ts)
+		transport = &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, ts),
+			Base:   transport,
+		}
 	}
-	return gh.NewClient(tc)
+	return gh.NewClient(&http.Client{Transport: newScheduler(transport)})
 }
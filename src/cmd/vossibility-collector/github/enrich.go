@@ -0,0 +1,113 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cmd/vossibility-collector/storage"
+
+	"github.com/google/go-github/github"
+)
+
+// Enrichment is a kind of additional data retrieved for each pull request by
+// a synchronization job.
+type Enrichment string
+
+const (
+	// EnrichReviews retrieves the reviews of the pull request, and its
+	// requested reviewers and teams.
+	EnrichReviews Enrichment = "reviews"
+
+	// EnrichCI retrieves the commit statuses and check runs of the head
+	// commit of the pull request.
+	EnrichCI Enrichment = "ci"
+)
+
+// enrichments is the list of all known enrichments.
+var enrichments = []Enrichment{
+	EnrichReviews,
+	EnrichCI,
+}
+
+// ParseEnrichments parses a list of enrichment names.
+func ParseEnrichments(names []string) ([]Enrichment, error) {
+	var out []Enrichment
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, e := range enrichments {
+			if string(e) == name {
+				out = append(out, e)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown pull request enrichment %q", name)
+		}
+	}
+	return out, nil
+}
+
+// requestedReviewers is the response of the requested reviewers API.
+type requestedReviewers struct {
+	Users []json.RawMessage `json:"users"`
+	Teams []json.RawMessage `json:"teams"`
+}
+
+// enrichPullRequest retrieves the requested additional data for the pull
+// request. An enrichment which fails is left out, and the last error is
+// returned.
+func enrichPullRequest(cli *github.Client, repo *storage.Repository, pr *githubEnrichedPR, enrich []Enrichment) error {
+	var lastErr error
+	for _, e := range enrich {
+		switch e {
+		case EnrichReviews:
+			if err := enrichReviews(cli, repo, pr); err != nil {
+				lastErr = fmt.Errorf("retrieve reviews for pull request %d: %v", *pr.Number, err)
+			}
+		case EnrichCI:
+			if err := enrichCI(cli, repo, pr); err != nil {
+				lastErr = fmt.Errorf("retrieve CI status for pull request %d: %v", *pr.Number, err)
+			}
+		}
+	}
+	return lastErr
+}
+
+func enrichReviews(cli *github.Client, repo *storage.Repository, pr *githubEnrichedPR) error {
+	reviews, err := listAllRaw(cli, fmt.Sprintf("repos/%s/%s/pulls/%d/reviews", repo.User, repo.Repo, *pr.Number), nil)
+	if err != nil {
+		return err
+	}
+
+	req, err := cli.NewRequest("GET", fmt.Sprintf("repos/%s/%s/pulls/%d/requested_reviewers", repo.User, repo.Repo, *pr.Number), nil)
+	if err != nil {
+		return err
+	}
+	var requested requestedReviewers
+	if _, err := cli.Do(req, &requested); err != nil {
+		return err
+	}
+
+	pr.Reviews = reviews
+	pr.RequestedReviewers = requested.Users
+	pr.RequestedTeams = requested.Teams
+	return nil
+}
+
+func enrichCI(cli *github.Client, repo *storage.Repository, pr *githubEnrichedPR) error {
+	if pr.Head == nil || pr.Head.SHA == nil {
+		return fmt.Errorf("unknown head commit")
+	}
+	ci, err := fetchCIStatus(cli, repo, *pr.Head.SHA)
+	if err != nil {
+		return err
+	}
+	pr.CI = ci
+	return nil
+}
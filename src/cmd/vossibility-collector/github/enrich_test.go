@@ -0,0 +1,73 @@
+package github
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseEnrichments(t *testing.T) {
+	if e, err := ParseEnrichments([]string{" reviews", ""}); err != nil {
+		t.Fatalf("unexpected error parsing enrichments: %v", err)
+	} else if len(e) != 1 || e[0] != EnrichReviews {
+		t.Fatalf("unexpected enrichments %v", e)
+	}
+
+	if _, err := ParseEnrichments([]string{"invalid"}); err == nil {
+		t.Fatal("expected error for unknown enrichment")
+	}
+}
+
+func TestEnrichPullRequestReviews(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/icecrime/repo/pulls/1/reviews", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`[{"id": 1, "state": "APPROVED"}, {"id": 2, "state": "CHANGES_REQUESTED"}]`))
+	})
+	mux.HandleFunc("/repos/icecrime/repo/pulls/1/requested_reviewers", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"users": [{"login": "icecrime"}], "teams": [{"slug": "maintainers"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	var pr githubEnrichedPR
+	if err := json.Unmarshal([]byte(`{"number": 1}`), &pr); err != nil {
+		t.Fatalf("failed to unmarshal pull request: %v", err)
+	}
+	if err := enrichPullRequest(client, &testRepository, &pr, []Enrichment{EnrichReviews}); err != nil {
+		t.Fatalf("unexpected error enriching pull request: %v", err)
+	}
+
+	// The additional data is exposed as attributes of the pull request.
+	b, err := json.Marshal(&pr)
+	if err != nil {
+		t.Fatalf("failed to marshal pull request: %v", err)
+	}
+	var v struct {
+		Reviews []struct {
+			State string `json:"state"`
+		} `json:"reviews"`
+		RequestedReviewers []struct {
+			Login string `json:"login"`
+		} `json:"requested_reviewers"`
+		RequestedTeams []struct {
+			Slug string `json:"slug"`
+		} `json:"requested_teams"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatalf("failed to unmarshal enriched pull request: %v", err)
+	}
+	if len(v.Reviews) != 2 || v.Reviews[0].State != "APPROVED" || v.Reviews[1].State != "CHANGES_REQUESTED" {
+		t.Fatalf("unexpected reviews %s", b)
+	}
+	if len(v.RequestedReviewers) != 1 || v.RequestedReviewers[0].Login != "icecrime" {
+		t.Fatalf("unexpected requested reviewers %s", b)
+	}
+	if len(v.RequestedTeams) != 1 || v.RequestedTeams[0].Slug != "maintainers" {
+		t.Fatalf("unexpected requested teams %s", b)
+	}
+}
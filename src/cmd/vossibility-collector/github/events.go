@@ -1,6 +1,8 @@
 package github
 
 const (
+	EvtCheckRun                 = "check_run"
+	EvtCheckSuite               = "check_suite"
 	EvtCommitComment            = "commit_comment"
 	EvtCreate                   = "create"
 	EvtDelete                   = "delete"
@@ -12,11 +14,14 @@ const (
 	EvtGollum                   = "gollum"
 	EvtIssueComment             = "issue_comment"
 	EvtIssues                   = "issues"
+	EvtLabel                    = "label"
 	EvtMember                   = "member"
 	EvtMembership               = "membership"
+	EvtMilestone                = "milestone"
 	EvtPageBuild                = "page_build"
 	EvtPublic                   = "public"
 	EvtPullRequest              = "pull_request"
+	EvtPullRequestReview        = "pull_request_review"
 	EvtPullRequestReviewComment = "pull_request_review_comment"
 	EvtPush                     = "push"
 	EvtRelease                  = "release"
@@ -24,4 +29,6 @@ const (
 	EvtStatus                   = "status"
 	EvtTeamAdd                  = "team_add"
 	EvtWatch                    = "watch"
+	EvtWorkflowJob              = "workflow_job"
+	EvtWorkflowRun              = "workflow_run"
 )
@@ -0,0 +1,154 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"cmd/vossibility-collector/storage"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// mediaTypeStarring is the media type which adds the starring time to the
+// listing of stargazers.
+const mediaTypeStarring = "application/vnd.github.v3.star+json"
+
+// githubEventItem is a past event reconstructed from the GitHub API in the
+// shape of the equivalent webhook delivery. It is stored as a live event, at
+// the time it happened, using the live transformation for its event type.
+type githubEventItem struct {
+	id        string
+	event     string
+	timestamp time.Time
+	payload   map[string]interface{}
+}
+
+func (g *githubEventItem) ID() string {
+	return g.id
+}
+
+func (g *githubEventItem) Type() string {
+	return g.event
+}
+
+func (g *githubEventItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.payload)
+}
+
+// repositoryPayload returns the repository attribute of reconstructed
+// payloads. The current attributes of the repository (such as its number of
+// stargazers) don't reflect its state at the time of past events, and are left
+// out.
+func repositoryPayload(r *storage.Repository) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      r.Repo,
+		"full_name": r.FullName(),
+		"owner": map[string]interface{}{
+			"login": r.User,
+		},
+	}
+}
+
+// stargazer is an entry of the stargazers listing with the starring media
+// type.
+type stargazer struct {
+	StarredAt time.Time       `json:"starred_at"`
+	User      json.RawMessage `json:"user"`
+}
+
+// stargazersIndexer returns a githubPagedIndexer listing the stargazers of
+// the repository as "watch" events.
+func stargazersIndexer(client *github.Client, r *storage.Repository, perPage int) githubPagedIndexer {
+	path := fmt.Sprintf("repos/%s/%s/stargazers", r.User, r.Repo)
+	return func(page int) ([]githubIndexedItem, *github.Response, error) {
+		req, err := newListRequest(client, path, nil, page, perPage)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Accept", mediaTypeStarring)
+
+		var stargazers []stargazer
+		resp, err := client.Do(req, &stargazers)
+		if err != nil {
+			return nil, resp, err
+		}
+		items := make([]githubIndexedItem, 0, len(stargazers))
+		for _, s := range stargazers {
+			var user struct {
+				Login string `json:"login"`
+			}
+			if err := json.Unmarshal(s.User, &user); err != nil {
+				return nil, resp, err
+			}
+			items = append(items, &githubEventItem{
+				id:        "star-" + user.Login,
+				event:     EvtWatch,
+				timestamp: s.StarredAt,
+				payload: map[string]interface{}{
+					"action":     "started",
+					"repository": repositoryPayload(r),
+					"sender":     s.User,
+					"starred_at": s.StarredAt,
+				},
+			})
+		}
+		return items, resp, nil
+	}
+}
+
+// fork is the subset of a forked repository used to reconstruct the event.
+type fork struct {
+	ID        int             `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Owner     json.RawMessage `json:"owner"`
+}
+
+// forksIndexer returns a githubPagedIndexer listing the forks of the
+// repository as "fork" events.
+func forksIndexer(client *github.Client, r *storage.Repository, perPage int) githubPagedIndexer {
+	path := fmt.Sprintf("repos/%s/%s/forks", r.User, r.Repo)
+	params := url.Values{}
+	params.Set("sort", "oldest")
+	return func(page int) ([]githubIndexedItem, *github.Response, error) {
+		objects, resp, err := listRaw(client, path, params, page, perPage)
+		if err != nil {
+			return nil, resp, err
+		}
+		items := make([]githubIndexedItem, 0, len(objects))
+		for _, o := range objects {
+			var f fork
+			if err := json.Unmarshal(o, &f); err != nil {
+				return nil, resp, err
+			}
+			items = append(items, &githubEventItem{
+				id:        fmt.Sprintf("fork-%d", f.ID),
+				event:     EvtFork,
+				timestamp: f.CreatedAt,
+				payload: map[string]interface{}{
+					"forkee":     o,
+					"repository": repositoryPayload(r),
+					"sender":     f.Owner,
+				},
+			})
+		}
+		return items, resp, nil
+	}
+}
+
+// fetchRepositoryHistory queries the GitHub API for the stargazers or the
+// forks of a repository, which are stored as past live events. Nothing is
+// retrieved when the repository isn't subscribed to the corresponding event.
+func (s *syncCmd) fetchRepositoryHistory(r *repoSync, target SyncTarget) error {
+	event, indexer := EvtWatch, stargazersIndexer(s.client, r.Repository, s.options.PerPage)
+	if target == SyncTargetForks {
+		event, indexer = EvtFork, forksIndexer(s.client, r.Repository, s.options.PerPage)
+	}
+	if !r.IsSubscribed(event) {
+		log.Infof("repository %s isn't subscribed to %q events: not retrieving %s", r.PrettyName(), event, target)
+		return nil
+	}
+	return s.fetchPaged(r, string(target), indexer)
+}
@@ -0,0 +1,59 @@
+package github
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"cmd/vossibility-collector/storage"
+	"cmd/vossibility-collector/transformation"
+)
+
+func TestSyncStargazers(t *testing.T) {
+	var accept string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/icecrime/repo/stargazers", func(w http.ResponseWriter, req *http.Request) {
+		accept = req.Header.Get("Accept")
+		w.Write([]byte(`[{"starred_at": "2014-06-01T12:00:00Z", "user": {"login": "icecrime"}}]`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.Targets = []SyncTarget{SyncTargetStargazers}
+
+	// Nothing is retrieved for a repository which isn't subscribed to the
+	// "watch" event.
+	repo := testRepository
+	store := &testBlobStore{}
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&repo})
+	if len(store.ids) != 0 {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+
+	// Stargazers are stored as live events at the time they starred.
+	repo.EventSet = storage.EventSet{EvtWatch: transformation.NewTransformation()}
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&repo})
+	if accept != mediaTypeStarring {
+		t.Fatalf("unexpected media type %q", accept)
+	}
+	if len(store.blobs) != 1 {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+	b := store.blobs[0]
+	if b.ID != "star-icecrime" || b.Type != EvtWatch || store.storages[0] != storage.StoreLiveEvent {
+		t.Fatalf("unexpected stored item %q of type %q in storage %v", b.ID, b.Type, store.storages[0])
+	}
+	if expected := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC); !b.Timestamp.Equal(expected) {
+		t.Fatalf("unexpected timestamp %v, expected %v", b.Timestamp, expected)
+	}
+	if login := b.Data.GetPath("sender", "login").MustString(); login != "icecrime" {
+		t.Fatalf("unexpected sender %q", login)
+	}
+}
@@ -0,0 +1,57 @@
+package github
+
+import (
+	"fmt"
+
+	"github.com/google/go-github/github"
+)
+
+// ownerTypeOrganization is the GitHub account type for organizations.
+const ownerTypeOrganization = "Organization"
+
+// OwnedRepository is the subset of the GitHub repository attributes used to
+// discover the repositories of a user or an organization.
+//
+// The vendored client library predates the "archived" attribute, which is why
+// we decode the API response ourselves.
+type OwnedRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Fork     bool `json:"fork"`
+	Archived bool `json:"archived"`
+}
+
+// ListOwnerRepositories returns all repositories of the specified GitHub user
+// or organization.
+func ListOwnerRepositories(client *github.Client, owner string) ([]*OwnedRepository, error) {
+	u, _, err := client.Users.Get(owner)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve owner %q: %v", owner, err)
+	}
+
+	// The organization endpoint includes the private repositories the token
+	// has access to, whereas the user endpoint only lists public ones.
+	path := fmt.Sprintf("users/%s/repos?type=owner", owner)
+	if u.Type != nil && *u.Type == ownerTypeOrganization {
+		path = fmt.Sprintf("orgs/%s/repos?type=all", owner)
+	}
+
+	var repos []*OwnedRepository
+	for page := 1; page != 0; {
+		req, err := client.NewRequest("GET", fmt.Sprintf("%s&per_page=100&page=%d", path, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var batch []*OwnedRepository
+		resp, err := client.Do(req, &batch)
+		if err != nil {
+			return nil, fmt.Errorf("list repositories for %q: %v", owner, err)
+		}
+		repos = append(repos, batch...)
+		page = resp.NextPage
+	}
+	return repos, nil
+}
@@ -0,0 +1,195 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/go-github/github"
+)
+
+// DefaultPollInterval is the delay between two polls of the Events API when
+// GitHub doesn't specify one through the X-Poll-Interval header.
+const DefaultPollInterval = 60 * time.Second
+
+// RepositoryEvent is an entry of the GitHub Events API.
+type RepositoryEvent struct {
+	ID    string          `json:"id"`
+	Type  string          `json:"type"`
+	Actor json.RawMessage `json:"actor"`
+	Repo  struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"repo"`
+	Org       json.RawMessage `json:"org,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// DeliveryID returns a stable identifier for the event, which plays the role
+// of the webhook delivery identifier.
+func (e *RepositoryEvent) DeliveryID() string {
+	return "event-" + e.ID
+}
+
+// EventType returns the webhook event type corresponding to the Events API
+// type (e.g., "PullRequestEvent" becomes "pull_request").
+func (e *RepositoryEvent) EventType() string {
+	name := strings.TrimSuffix(e.Type, "Event")
+	var out []rune
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				out = append(out, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// WebhookPayload converts the Events API entry into the payload of the
+// equivalent webhook delivery. The Events API payload lacks the repository
+// and sender information which are found in webhook deliveries, so we
+// reconstruct them from the event metadata.
+func (e *RepositoryEvent) WebhookPayload() ([]byte, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("invalid payload for event %s: %v", e.ID, err)
+	}
+	if payload == nil {
+		payload = make(map[string]interface{})
+	}
+
+	if _, ok := payload["repository"]; !ok {
+		name := e.Repo.Name
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		payload["repository"] = map[string]interface{}{
+			"id":        e.Repo.ID,
+			"name":      name,
+			"full_name": e.Repo.Name,
+			"url":       e.Repo.URL,
+		}
+	}
+	if _, ok := payload["sender"]; !ok && len(e.Actor) != 0 {
+		payload["sender"] = e.Actor
+	}
+	if _, ok := payload["organization"]; !ok && len(e.Org) != 0 {
+		payload["organization"] = e.Org
+	}
+	return json.Marshal(payload)
+}
+
+// EventPoller retrieves the new events for a repository from the GitHub Events
+// API. It relies on conditional requests (which don't count against the rate
+// limit) to avoid downloading the same events over and over.
+type EventPoller struct {
+	// Cursor is the identifier of the last processed event.
+	Cursor int64
+
+	// ETag is the entity tag of the last fully processed response.
+	ETag string
+
+	client *github.Client
+	user   string
+	repo   string
+
+	// pendingETag and pendingCursor are the values of ETag and Cursor once all
+	// events from the last poll are processed.
+	pendingETag   string
+	pendingCursor int64
+}
+
+// NewEventPoller creates an EventPoller for the specified repository.
+func NewEventPoller(client *github.Client, user, repo string) *EventPoller {
+	return &EventPoller{
+		client: client,
+		user:   user,
+		repo:   repo,
+	}
+}
+
+// Poll returns the events which happened after the cursor in chronological
+// order, along with the delay to wait for before polling again. The caller is
+// expected to call Advance for each event once processed.
+func (p *EventPoller) Poll() ([]*RepositoryEvent, time.Duration, error) {
+	var events []*RepositoryEvent
+	interval := DefaultPollInterval
+
+	// The API lists most recent events first: we page until we reach an event
+	// we already know about.
+	for page := 1; page != 0; {
+		u := fmt.Sprintf("repos/%s/%s/events?page=%d", p.user, p.repo, page)
+		req, err := p.client.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, interval, err
+		}
+		if page == 1 && p.ETag != "" {
+			req.Header.Set("If-None-Match", p.ETag)
+		}
+
+		var batch []*RepositoryEvent
+		resp, err := p.client.Do(req, &batch)
+		if resp != nil && page == 1 {
+			if v, err := strconv.Atoi(resp.Header.Get("X-Poll-Interval")); err == nil && v > 0 {
+				interval = time.Duration(v) * time.Second
+			}
+			if resp.StatusCode == http.StatusNotModified {
+				return nil, interval, nil
+			}
+			p.pendingETag = resp.Header.Get("ETag")
+		}
+		if err != nil {
+			return nil, interval, err
+		}
+
+		reachedCursor := false
+		for _, e := range batch {
+			id, err := strconv.ParseInt(e.ID, 10, 64)
+			if err != nil {
+				return nil, interval, fmt.Errorf("invalid event id %q", e.ID)
+			}
+			if id <= p.Cursor {
+				reachedCursor = true
+				break
+			}
+			events = append(events, e)
+		}
+		if reachedCursor {
+			break
+		}
+		page = resp.NextPage
+	}
+
+	// Reverse the events to return them in chronological order.
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if len(events) == 0 {
+		p.ETag = p.pendingETag
+		return nil, interval, nil
+	}
+	p.pendingCursor, _ = strconv.ParseInt(events[len(events)-1].ID, 10, 64)
+	return events, interval, nil
+}
+
+// Advance moves the cursor past the specified event. The ETag of the last poll
+// is only retained once all of its events are processed: otherwise, the next
+// poll would be answered with a "not modified" response.
+func (p *EventPoller) Advance(e *RepositoryEvent) {
+	id, _ := strconv.ParseInt(e.ID, 10, 64)
+	if id > p.Cursor {
+		p.Cursor = id
+	}
+	if p.Cursor >= p.pendingCursor {
+		p.ETag = p.pendingETag
+	}
+}
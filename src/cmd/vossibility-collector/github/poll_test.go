@@ -0,0 +1,118 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRepositoryEventType(t *testing.T) {
+	for typ, expected := range map[string]string{
+		"CheckRunEvent":                 EvtCheckRun,
+		"IssuesEvent":                   EvtIssues,
+		"IssueCommentEvent":             EvtIssueComment,
+		"PullRequestEvent":              EvtPullRequest,
+		"PullRequestReviewEvent":        EvtPullRequestReview,
+		"PullRequestReviewCommentEvent": EvtPullRequestReviewComment,
+		"WatchEvent":                    EvtWatch,
+		"WorkflowRunEvent":              EvtWorkflowRun,
+	} {
+		e := RepositoryEvent{Type: typ}
+		if v := e.EventType(); v != expected {
+			t.Fatalf("got event type %q for %q, expected %q", v, typ, expected)
+		}
+	}
+}
+
+func TestRepositoryEventWebhookPayload(t *testing.T) {
+	var e RepositoryEvent
+	if err := json.Unmarshal([]byte(`{
+		"id": "12345",
+		"type": "IssuesEvent",
+		"actor": {"login": "icecrime"},
+		"repo": {"id": 1, "name": "icecrime/repo", "url": "https://api.github.com/repos/icecrime/repo"},
+		"payload": {"action": "opened", "issue": {"number": 1}}
+	}`), &e); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+
+	b, err := e.WebhookPayload()
+	if err != nil {
+		t.Fatalf("failed to convert event: %v", err)
+	}
+	if v, err := RepositoryFullName(b); err != nil || v != "icecrime/repo" {
+		t.Fatalf("unexpected repository name %q (err=%v)", v, err)
+	}
+
+	var p struct {
+		Action string
+		Sender struct{ Login string }
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if p.Action != "opened" || p.Sender.Login != "icecrime" {
+		t.Fatalf("unexpected payload %s", b)
+	}
+}
+
+func TestEventPoller(t *testing.T) {
+	var events []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/icecrime/repo/events", func(w http.ResponseWriter, req *http.Request) {
+		etag := fmt.Sprintf(`"%d"`, len(events))
+		w.Header().Set("X-Poll-Interval", "42")
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		var out []map[string]string
+		for i := len(events) - 1; i >= 0; i-- {
+			out = append(out, map[string]string{"id": events[i], "type": "WatchEvent"})
+		}
+		json.NewEncoder(w).Encode(out)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := NewClient("")
+	c.BaseURL, _ = url.Parse(srv.URL + "/")
+	p := NewEventPoller(c, "icecrime", "repo")
+
+	// First poll with a couple of events.
+	events = []string{"1", "2"}
+	res, interval, err := p.Poll()
+	if err != nil {
+		t.Fatalf("unexpected error polling events: %v", err)
+	}
+	if interval.Seconds() != 42 {
+		t.Fatalf("unexpected poll interval %v", interval)
+	}
+	if len(res) != 2 || res[0].ID != "1" || res[1].ID != "2" {
+		t.Fatalf("unexpected events %v", res)
+	}
+
+	// Only advancing past the first event shouldn't retain the ETag: the
+	// second event is returned again.
+	p.Advance(res[0])
+	if res, _, err = p.Poll(); err != nil || len(res) != 1 || res[0].ID != "2" {
+		t.Fatalf("unexpected events %v (err=%v)", res, err)
+	}
+	p.Advance(res[0])
+
+	// Nothing changed: the poll is answered with "not modified".
+	if res, _, err = p.Poll(); err != nil || len(res) != 0 {
+		t.Fatalf("unexpected events %v (err=%v)", res, err)
+	}
+
+	// New events are returned past the cursor.
+	events = append(events, "3")
+	if res, _, err = p.Poll(); err != nil || len(res) != 1 || res[0].ID != "3" {
+		t.Fatalf("unexpected events %v (err=%v)", res, err)
+	}
+}
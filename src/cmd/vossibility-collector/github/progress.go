@@ -0,0 +1,105 @@
+package github
+
+import (
+	"sync"
+	"time"
+)
+
+// syncProgress is the position up to which a synchronization job has fully
+// processed the listing of a repository.
+type syncProgress struct {
+	// Page is the last page of which all items were indexed.
+	Page int `json:"page"`
+
+	// Number is the highest item number listed up to Page.
+	Number int `json:"number"`
+
+	// UpdatedAt is the most recent update time of the items listed up to
+	// Page.
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// State, Since and PerPage are the parameters of the interrupted
+	// listing, which the progress only makes sense for.
+	State   GitHubStateFilter `json:"state"`
+	Since   time.Time         `json:"since,omitempty"`
+	PerPage int               `json:"per_page"`
+
+	// StartedAt is when the interrupted job started, which is where the
+	// checkpoint goes once the job is resumed and complete.
+	StartedAt time.Time `json:"started_at"`
+}
+
+// pageTracker keeps track of the items of a paged listing which are still
+// queued for fetching or indexing. This allows to know the point up to which
+// the listing was fully processed, even though items are processed out of
+// order by multiple goroutines.
+type pageTracker struct {
+	mu sync.Mutex
+
+	// pages holds the pages which aren't fully processed yet, in the order
+	// they were listed.
+	pages []*trackedPage
+
+	// done is the progress up to the last fully processed page.
+	done syncProgress
+
+	// onProgress is called (with the lock held) each time done moves forward.
+	onProgress func(syncProgress)
+}
+
+type trackedPage struct {
+	pending  int
+	progress syncProgress
+}
+
+// newPageTracker creates a pageTracker starting from the specified progress.
+func newPageTracker(start syncProgress, onProgress func(syncProgress)) *pageTracker {
+	return &pageTracker{
+		done:       start,
+		onProgress: onProgress,
+	}
+}
+
+// Add registers a listed page with the number of items queued from it, and
+// the progress once all of them are processed. Pages must be added in order.
+func (t *pageTracker) Add(page, queued int, progress syncProgress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	progress.Page = page
+	t.pages = append(t.pages, &trackedPage{pending: queued, progress: progress})
+	t.advance()
+}
+
+// Done marks one of the items queued from the page as processed.
+func (t *pageTracker) Done(page int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.pages {
+		if p.progress.Page == page {
+			p.pending--
+			break
+		}
+	}
+	t.advance()
+}
+
+// Progress returns the progress up to the last fully processed page.
+func (t *pageTracker) Progress() syncProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.done
+}
+
+// advance moves the progress forward past the leading pages which are fully
+// processed.
+func (t *pageTracker) advance() {
+	moved := false
+	for len(t.pages) > 0 && t.pages[0].pending <= 0 {
+		t.done = t.pages[0].progress
+		t.pages = t.pages[1:]
+		moved = true
+	}
+	if moved && t.onProgress != nil {
+		t.onProgress(t.done)
+	}
+}
@@ -0,0 +1,41 @@
+package github
+
+import (
+	"testing"
+)
+
+func TestPageTracker(t *testing.T) {
+	var saved []int
+	tracker := newPageTracker(syncProgress{}, func(p syncProgress) {
+		saved = append(saved, p.Page)
+	})
+
+	tracker.Add(1, 2, syncProgress{Number: 2})
+	tracker.Add(2, 1, syncProgress{Number: 3})
+	tracker.Add(3, 0, syncProgress{Number: 3})
+
+	// Completing the second page doesn't move the progress as long as the
+	// first one isn't complete.
+	tracker.Done(2)
+	tracker.Done(1)
+	if p := tracker.Progress(); p.Page != 0 {
+		t.Fatalf("unexpected progress past page %d", p.Page)
+	}
+
+	// Completing the first page moves the progress past all complete pages.
+	tracker.Done(1)
+	if p := tracker.Progress(); p.Page != 3 || p.Number != 3 {
+		t.Fatalf("unexpected progress %#v", p)
+	}
+	if len(saved) != 1 || saved[0] != 3 {
+		t.Fatalf("unexpected progress notifications %v", saved)
+	}
+}
+
+func TestPageTrackerEmptyPage(t *testing.T) {
+	tracker := newPageTracker(syncProgress{Page: 4}, nil)
+	tracker.Add(5, 0, syncProgress{})
+	if p := tracker.Progress(); p.Page != 5 {
+		t.Fatalf("unexpected progress past page %d, expected 5", p.Page)
+	}
+}
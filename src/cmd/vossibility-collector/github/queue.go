@@ -0,0 +1,101 @@
+package github
+
+import (
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// syncJob is a unit of work of a synchronization job: either an issue which is
+// really a pull request to fetch before indexing, or an item to index. The
+// page is the one of the listing of issues and pull requests the job comes
+// from, or zero for items which don't come from that listing.
+type syncJob struct {
+	repo  *repoSync
+	issue *github.Issue
+	item  githubIndexedItem
+	page  int
+}
+
+// jobQueue is the queue of jobs shared by all repositories of a
+// synchronization job.
+//
+// Jobs are dequeued in turn from each repository which has some queued, so
+// that a large repository doesn't delay the others, and each repository can
+// only have a limited number of jobs queued, which blocks its listing until
+// the workers catch up.
+type jobQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// limit is the maximum number of jobs queued for a repository.
+	limit int
+
+	// queues holds the queued jobs of each repository, and order the
+	// repositories which have queued jobs in the order they are served.
+	queues map[*repoSync][]syncJob
+	order  []*repoSync
+	closed bool
+}
+
+// newJobQueue creates a jobQueue with the specified per-repository limit.
+func newJobQueue(limit int) *jobQueue {
+	if limit < 1 {
+		limit = 1
+	}
+	q := &jobQueue{
+		limit:  limit,
+		queues: make(map[*repoSync][]syncJob),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push queues a job, and blocks as long as its repository has the maximum
+// number of jobs queued.
+func (q *jobQueue) Push(j syncJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.queues[j.repo]) >= q.limit {
+		q.cond.Wait()
+	}
+	if len(q.queues[j.repo]) == 0 {
+		q.order = append(q.order, j.repo)
+	}
+	q.queues[j.repo] = append(q.queues[j.repo], j)
+	q.cond.Broadcast()
+}
+
+// Pop dequeues the next job, and blocks until one is available. It returns
+// false once the queue is closed and all jobs were dequeued.
+func (q *jobQueue) Pop() (syncJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.order) == 0 {
+		if q.closed {
+			return syncJob{}, false
+		}
+		q.cond.Wait()
+	}
+
+	// Take the first job of the next repository in turn, which goes back at
+	// the end of the line if it has more queued.
+	r := q.order[0]
+	q.order = q.order[1:]
+	j := q.queues[r][0]
+	if q.queues[r] = q.queues[r][1:]; len(q.queues[r]) > 0 {
+		q.order = append(q.order, r)
+	} else {
+		delete(q.queues, r)
+	}
+	q.cond.Broadcast()
+	return j, true
+}
+
+// Close signals that no more jobs will be queued.
+func (q *jobQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,60 @@
+package github
+
+import (
+	"testing"
+)
+
+func TestJobQueueFairness(t *testing.T) {
+	large, small := &repoSync{}, &repoSync{}
+	q := newJobQueue(10)
+	for i := 1; i <= 4; i++ {
+		q.Push(syncJob{repo: large, page: i})
+	}
+	q.Push(syncJob{repo: small, page: 1})
+	q.Close()
+
+	// Repositories are served in turn, regardless of the number of jobs
+	// each of them has queued.
+	var order []*repoSync
+	for {
+		j, ok := q.Pop()
+		if !ok {
+			break
+		}
+		order = append(order, j.repo)
+	}
+	expected := []*repoSync{large, small, large, large, large}
+	if len(order) != len(expected) {
+		t.Fatalf("dequeued %d jobs, expected %d", len(order), len(expected))
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("unexpected repository for job %d", i)
+		}
+	}
+}
+
+func TestJobQueueLimit(t *testing.T) {
+	r := &repoSync{}
+	q := newJobQueue(1)
+	q.Push(syncJob{repo: r, page: 1})
+
+	// Pushing beyond the limit blocks until a job is dequeued.
+	pushed := make(chan struct{})
+	go func() {
+		q.Push(syncJob{repo: r, page: 2})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("job pushed beyond the limit of the repository")
+	default:
+	}
+	if j, _ := q.Pop(); j.page != 1 {
+		t.Fatalf("dequeued page %d, expected 1", j.page)
+	}
+	<-pushed
+	if j, _ := q.Pop(); j.page != 2 {
+		t.Fatalf("dequeued page %d, expected 2", j.page)
+	}
+}
@@ -0,0 +1,134 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/go-github/github"
+)
+
+// githubRawItem is a GitHub object indexed as returned by the API, which
+// preserves all of its attributes for the transformation.
+type githubRawItem struct {
+	id   string
+	typ  string
+	data json.RawMessage
+}
+
+func (g *githubRawItem) ID() string {
+	return g.id
+}
+
+func (g *githubRawItem) Type() string {
+	return g.typ
+}
+
+func (g *githubRawItem) MarshalJSON() ([]byte, error) {
+	return g.data, nil
+}
+
+// rawItems creates items of the specified type from the objects returned by
+// the API, identified by their "id" attribute.
+func rawItems(typ string, objects []json.RawMessage) ([]githubIndexedItem, error) {
+	return rawItemsByKey(typ, "id", objects)
+}
+
+// rawItemsByKey creates items of the specified type from the objects returned
+// by the API, identified by the specified attribute.
+func rawItemsByKey(typ, key string, objects []json.RawMessage) ([]githubIndexedItem, error) {
+	items := make([]githubIndexedItem, 0, len(objects))
+	for _, o := range objects {
+		var v map[string]json.RawMessage
+		if err := json.Unmarshal(o, &v); err != nil {
+			return nil, err
+		}
+		raw, ok := v[key]
+		if !ok {
+			return nil, fmt.Errorf("%s object has no %s", typ, key)
+		}
+		// The identifier is either a string or a number.
+		var id string
+		if err := json.Unmarshal(raw, &id); err != nil {
+			id = string(raw)
+		}
+		items = append(items, &githubRawItem{id: id, typ: typ, data: o})
+	}
+	return items, nil
+}
+
+// listRaw retrieves a page of the objects listed at the API path, with the
+// additional query parameters. Objects are kept as returned by the API, as
+// the vendored client library lacks many of their attributes (or doesn't know
+// about them at all).
+func listRaw(client *github.Client, path string, params url.Values, page, perPage int) ([]json.RawMessage, *github.Response, error) {
+	req, err := newListRequest(client, path, params, page, perPage)
+	if err != nil {
+		return nil, nil, err
+	}
+	var objects []json.RawMessage
+	resp, err := client.Do(req, &objects)
+	return objects, resp, err
+}
+
+// listRawField is like listRaw for the APIs which return the listed objects as
+// an attribute of the response rather than as a plain array.
+func listRawField(client *github.Client, path, field string, params url.Values, page, perPage int) ([]json.RawMessage, *github.Response, error) {
+	req, err := newListRequest(client, path, params, page, perPage)
+	if err != nil {
+		return nil, nil, err
+	}
+	var v map[string]json.RawMessage
+	resp, err := client.Do(req, &v)
+	if err != nil {
+		return nil, resp, err
+	}
+	var objects []json.RawMessage
+	if raw, ok := v[field]; ok {
+		if err := json.Unmarshal(raw, &objects); err != nil {
+			return nil, resp, err
+		}
+	}
+	return objects, resp, nil
+}
+
+// newListRequest creates the request for a page of the objects listed at the
+// API path, with the additional query parameters.
+func newListRequest(client *github.Client, path string, params url.Values, page, perPage int) (*http.Request, error) {
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+	return client.NewRequest("GET", path+"?"+query.Encode(), nil)
+}
+
+// listAllRaw retrieves all pages of the objects listed at the API path.
+func listAllRaw(client *github.Client, path string, params url.Values) ([]json.RawMessage, error) {
+	var all []json.RawMessage
+	for page := 1; page != 0; {
+		objects, resp, err := listRaw(client, path, params, page, DefaultPerPage)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, objects...)
+		page = resp.NextPage
+	}
+	return all, nil
+}
+
+// rawListIndexer returns a githubPagedIndexer listing the objects of the
+// specified type at the API path, with the additional query parameters.
+func rawListIndexer(client *github.Client, typ, path string, params url.Values, perPage int) githubPagedIndexer {
+	return func(page int) ([]githubIndexedItem, *github.Response, error) {
+		objects, resp, err := listRaw(client, path, params, page, perPage)
+		if err != nil {
+			return nil, resp, err
+		}
+		items, err := rawItems(typ, objects)
+		return items, resp, err
+	}
+}
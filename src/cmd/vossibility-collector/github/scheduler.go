@@ -0,0 +1,203 @@
+package github
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// MaxScheduledRetries is the number of times a request rejected because
+	// of rate limiting is retried.
+	MaxScheduledRetries = 3
+
+	// DefaultAbuseBackoff is the delay to wait for after a request triggered
+	// the abuse detection mechanism without GitHub telling how long to wait.
+	DefaultAbuseBackoff = 60 * time.Second
+
+	// MaxAbuseBackoff is the maximum total delay a request waits for after
+	// being rejected by the abuse detection mechanism, after which the
+	// rejection is returned. Callers may hold locks while waiting, such as
+	// the one pausing live events during a sync.
+	MaxAbuseBackoff = 3 * time.Minute
+
+	// maxErrorBodySize is the maximum size of an error response read to tell
+	// apart abuse detection from permission errors.
+	maxErrorBodySize = 64 * 1024
+
+	// maxJitter is the maximum fraction of a delay which is randomly added to
+	// it, so that concurrent requests don't all wake up at once.
+	maxJitter = 0.1
+)
+
+// scheduler is an http.RoundTripper which schedules the GitHub API requests
+// according to the rate limit. It reads the rate limit headers of every
+// response, and spreads the remaining requests evenly over the time left until
+// the limit is reset. Requests rejected because of rate limiting or abuse
+// detection are retried once the delay requested by GitHub has elapsed, for a
+// total of MaxAbuseBackoff at most in the case of abuse detection.
+//
+// All requests made through the same client share the same scheduler.
+type scheduler struct {
+	base http.RoundTripper
+
+	mu sync.Mutex
+
+	// remaining and reset are the rate limit status as of the last response.
+	remaining int
+	reset     time.Time
+
+	// next is the time of the next request slot, and pausedUntil the time
+	// before which no request should be made at all.
+	next        time.Time
+	pausedUntil time.Time
+
+	// now and sleep are replaced for testing.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// newScheduler creates a scheduler sending requests through the base
+// RoundTripper.
+func newScheduler(base http.RoundTripper) *scheduler {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &scheduler{
+		base:      base,
+		remaining: -1,
+		now:       time.Now,
+		sleep:     time.Sleep,
+	}
+}
+
+func (s *scheduler) RoundTrip(req *http.Request) (*http.Response, error) {
+	var backoff time.Duration // Total delay waited for because of abuse detection.
+	for attempt := 0; ; attempt++ {
+		s.sleep(s.reserve())
+		resp, err := s.base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+
+		abuse := isAbuseDetection(resp)
+		delay, limited := s.update(resp, attempt, abuse, MaxAbuseBackoff-backoff)
+		// Requests with a body can't be sent again, as it was consumed.
+		if !limited || attempt == MaxScheduledRetries || req.Body != nil {
+			return resp, nil
+		}
+		if abuse {
+			backoff += delay
+		}
+		log.Warnf("GitHub API request %s %s rejected by rate limiting: retrying in %s", req.Method, req.URL.Path, delay)
+		resp.Body.Close()
+	}
+}
+
+// reserve returns the delay to wait for before sending a request, and books
+// the corresponding slot.
+func (s *scheduler) reserve() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	slot := now
+	if s.next.After(slot) {
+		slot = s.next
+	}
+	if s.pausedUntil.After(slot) {
+		slot = s.pausedUntil
+	}
+
+	// Wait for the reset when the budget is exhausted, and otherwise spread
+	// the remaining requests over the time left until reset. We have no idea
+	// of the budget until we get the first response.
+	if s.remaining == 0 && s.reset.After(slot) {
+		slot = s.reset
+	}
+	var interval time.Duration
+	if s.remaining > 0 && s.reset.After(slot) {
+		interval = s.reset.Sub(slot) / time.Duration(s.remaining)
+		s.remaining--
+	}
+	s.next = slot.Add(interval)
+	return slot.Sub(now)
+}
+
+// update records the rate limit status from the response. It returns whether
+// the request was rejected because of rate limiting, and if so how long to
+// wait for before retrying. Rejections by the abuse detection mechanism aren't
+// retried when the delay exceeds the remaining backoff budget.
+func (s *scheduler) update(resp *http.Response, attempt int, abuse bool, budget time.Duration) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if v, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		s.remaining = v
+	}
+	if v, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		s.reset = time.Unix(v, 0)
+	}
+
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	// Abuse detection responses ask to wait for the Retry-After delay, or
+	// otherwise to back off exponentially, whereas primary rate limit
+	// responses report no remaining requests.
+	var delay time.Duration
+	switch {
+	case abuse:
+		delay = DefaultAbuseBackoff << uint(attempt)
+		if v, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			delay = time.Duration(v) * time.Second
+		}
+	case s.remaining == 0 && s.reset.After(now):
+		delay = s.reset.Sub(now)
+	default:
+		return 0, false // Genuine permission error
+	}
+
+	delay += time.Duration(rand.Float64() * maxJitter * float64(delay))
+	if abuse && delay > budget {
+		return 0, false
+	}
+	if until := now.Add(delay); until.After(s.pausedUntil) {
+		s.pausedUntil = until
+	}
+	return delay, true
+}
+
+// isAbuseDetection returns whether the response is a rejection by the abuse
+// detection mechanism (also known as secondary rate limits), which GitHub
+// identifies by a Retry-After header or by the message of the response. The
+// body read is put back in front of the rest of the response body.
+func isAbuseDetection(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	if resp.Header.Get("Retry-After") != "" {
+		return true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil {
+		return false
+	}
+	message := strings.ToLower(string(body))
+	return strings.Contains(message, "secondary rate limit") || strings.Contains(message, "abuse")
+}
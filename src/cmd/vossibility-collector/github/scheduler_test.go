@@ -0,0 +1,160 @@
+package github
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTransport answers requests with the responses from the list.
+type testTransport struct {
+	responses []*http.Response
+	requests  int
+}
+
+func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := t.responses[t.requests]
+	t.requests++
+	return resp, nil
+}
+
+func testResponse(status int, headers map[string]string) *http.Response {
+	resp := &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("{}")),
+	}
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+func testScheduler(responses ...*http.Response) (*scheduler, *testTransport, *[]time.Duration) {
+	now := time.Unix(1000, 0)
+	var sleeps []time.Duration
+	transport := &testTransport{responses: responses}
+	s := newScheduler(transport)
+	s.now = func() time.Time { return now }
+	s.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+	}
+	return s, transport, &sleeps
+}
+
+func TestSchedulerSpreadsRequests(t *testing.T) {
+	reset := strconv.Itoa(1000 + 100)
+	s, _, sleeps := testScheduler(
+		testResponse(http.StatusOK, map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": reset}),
+		testResponse(http.StatusOK, map[string]string{"X-RateLimit-Remaining": "9", "X-RateLimit-Reset": reset}),
+		testResponse(http.StatusOK, map[string]string{"X-RateLimit-Remaining": "8", "X-RateLimit-Reset": reset}),
+	)
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	for i := 0; i != 3; i++ {
+		if _, err := s.RoundTrip(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The first request is sent immediately, and the next ones are spread
+	// over the time left until reset.
+	if expected := []time.Duration{0, 0, 10 * time.Second}; len(*sleeps) != 3 || (*sleeps)[0] != expected[0] || (*sleeps)[2] != expected[2] {
+		t.Fatalf("unexpected delays %v, expected %v", *sleeps, expected)
+	}
+}
+
+func TestSchedulerRetryAfter(t *testing.T) {
+	s, transport, sleeps := testScheduler(
+		testResponse(http.StatusForbidden, map[string]string{"Retry-After": "30"}),
+		testResponse(http.StatusOK, nil),
+	)
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	resp, err := s.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected result (resp=%v, err=%v)", resp, err)
+	}
+	if transport.requests != 2 {
+		t.Fatalf("unexpected number of requests %d, expected 2", transport.requests)
+	}
+	if d := (*sleeps)[1]; d < 30*time.Second || d > 33*time.Second {
+		t.Fatalf("unexpected delay %v before retrying", d)
+	}
+}
+
+func TestSchedulerPermissionError(t *testing.T) {
+	s, transport, _ := testScheduler(
+		testResponse(http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "42"}),
+	)
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	if resp, err := s.RoundTrip(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected result (resp=%v, err=%v)", resp, err)
+	}
+	if transport.requests != 1 {
+		t.Fatalf("unexpected number of requests %d, expected 1", transport.requests)
+	}
+}
+
+// abuseResponse returns a response rejecting a request because of the abuse
+// detection mechanism, as identified by its message only.
+func abuseResponse() *http.Response {
+	resp := testResponse(http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "42"})
+	resp.Body = ioutil.NopCloser(strings.NewReader(`{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`))
+	return resp
+}
+
+func TestSchedulerAbuseBackoff(t *testing.T) {
+	s, transport, sleeps := testScheduler(abuseResponse(), abuseResponse(), abuseResponse(), abuseResponse())
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	resp, err := s.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected result (resp=%v, err=%v)", resp, err)
+	}
+
+	// The request backs off exponentially until the total delay would exceed
+	// the maximum, and the rejection is returned with its body.
+	if transport.requests != 2 {
+		t.Fatalf("unexpected number of requests %d, expected 2", transport.requests)
+	}
+	var total time.Duration
+	for _, d := range *sleeps {
+		total += d
+	}
+	if total < DefaultAbuseBackoff || total > MaxAbuseBackoff {
+		t.Fatalf("unexpected total delay %v", total)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); !strings.Contains(string(body), "secondary rate limit") {
+		t.Fatalf("unexpected response body %q", body)
+	}
+}
+
+func TestSchedulerRetryAfterTooLong(t *testing.T) {
+	s, transport, sleeps := testScheduler(
+		testResponse(http.StatusForbidden, map[string]string{"Retry-After": "3600"}),
+		testResponse(http.StatusOK, nil),
+	)
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	if resp, err := s.RoundTrip(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected result (resp=%v, err=%v)", resp, err)
+	}
+	if transport.requests != 1 || (*sleeps)[0] != 0 {
+		t.Fatalf("request waited for the Retry-After delay (requests=%d, delays=%v)", transport.requests, *sleeps)
+	}
+}
+
+func TestSchedulerForbiddenWithoutRateLimit(t *testing.T) {
+	s, transport, _ := testScheduler(
+		testResponse(http.StatusForbidden, nil),
+		testResponse(http.StatusOK, nil),
+	)
+	req, _ := http.NewRequest("GET", "https://api.github.com/", nil)
+	if resp, err := s.RoundTrip(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected result (resp=%v, err=%v)", resp, err)
+	}
+	if transport.requests != 1 {
+		t.Fatalf("unexpected number of requests %d, expected 1", transport.requests)
+	}
+}
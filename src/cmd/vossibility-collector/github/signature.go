@@ -0,0 +1,46 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// signatureAlgorithms maps the prefixes of the GitHub signature headers to
+// their hashing function.
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// VerifySignature checks a GitHub signature header value (such as
+// "sha1=<hex digest>" or "sha256=<hex digest>") against the HMAC of the
+// payload computed using the shared secret.
+func VerifySignature(secret string, signature string, payload []byte) error {
+	if signature == "" {
+		return fmt.Errorf("missing signature: no X-Hub-Signature-256 or X-Hub-Signature header")
+	}
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed signature %q", signature)
+	}
+	algorithm, ok := signatureAlgorithms[parts[0]]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm %q", parts[0])
+	}
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed signature %q: %v", signature, err)
+	}
+
+	mac := hmac.New(algorithm, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
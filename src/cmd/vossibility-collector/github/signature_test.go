@@ -0,0 +1,82 @@
+package github
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const (
+	testSecret  = "s3cr3t"
+	testPayload = `{"action":"opened"}`
+)
+
+func TestVerifySignature(t *testing.T) {
+	for _, signature := range []string{
+		"sha1=b4d2b4f2e6ec9a25b9e2b4b8f2c4a3b2ad3d0cb7",
+		"sha256=4c7d7d1b2f08a4a3b1e6b6b4e0e1a0b9b2f7ab4c4d4fb4ad58bd8b38b3c8cbb5",
+	} {
+		if err := VerifySignature(testSecret, signature, []byte(testPayload)); err == nil || !strings.Contains(err.Error(), "mismatch") {
+			t.Fatalf("expected mismatch error for signature %q, got %v", signature, err)
+		}
+	}
+
+	for _, signature := range []string{
+		"sha1=94db619669011d2443ad27bfb1880ef60a756f97",
+		"sha256=893b8b176c39b217756497563a1b9ba1507d371642b1edf4ee9f876b8fd74ecc",
+	} {
+		if err := VerifySignature(testSecret, signature, []byte(testPayload)); err != nil {
+			t.Fatalf("unexpected error verifying signature %q: %v", signature, err)
+		}
+		if err := VerifySignature("other", signature, []byte(testPayload)); err == nil {
+			t.Fatalf("expected error verifying signature %q with the wrong secret", signature)
+		}
+	}
+}
+
+func TestVerifySignatureMalformed(t *testing.T) {
+	for signature, expected := range map[string]string{
+		"":           "missing signature",
+		"sha1":       "malformed signature",
+		"sha1=zz":    "malformed signature",
+		"md5=abcdef": "unsupported signature algorithm",
+	} {
+		if err := VerifySignature(testSecret, signature, []byte(testPayload)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q error for signature %q, got %v", expected, signature, err)
+		}
+	}
+}
+
+func TestPartialMessageSignature(t *testing.T) {
+	p := PartialMessage{HubSignature: "sha1=abc"}
+	if v := p.Signature(); v != p.HubSignature {
+		t.Fatalf("got signature %q, expected %q", v, p.HubSignature)
+	}
+	p.HubSignature256 = "sha256=def"
+	if v := p.Signature(); v != p.HubSignature256 {
+		t.Fatalf("got signature %q, expected %q", v, p.HubSignature256)
+	}
+}
+
+func TestPartialMessageRawPayload(t *testing.T) {
+	body := []byte(`{"X-GitHub-Event":"issues","payload":{"action":"opened"}}`)
+	var p PartialMessage
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("unexpected error unmarshaling message: %v", err)
+	}
+	if v := string(p.RawPayload(body)); v != `{"action":"opened"}` {
+		t.Fatalf("unexpected raw payload %s", v)
+	}
+
+	body = []byte(`{"X-GitHub-Event":"issues","action":"opened"}`)
+	p = PartialMessage{}
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("unexpected error unmarshaling message: %v", err)
+	}
+	if !p.IsMerged() {
+		t.Fatal("message not reported as merged")
+	}
+	if v := string(p.RawPayload(body)); v != string(body) {
+		t.Fatalf("unexpected raw payload %s", v)
+	}
+}
@@ -2,10 +2,14 @@ package github
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"cmd/vossibility-collector/blob"
+	"cmd/vossibility-collector/state"
 	"cmd/vossibility-collector/storage"
 
 	log "github.com/Sirupsen/logrus"
@@ -27,10 +31,29 @@ const (
 	GitHubStateFilterOpened GitHubStateFilter = "open"
 )
 
+// GitHubItemFilter is an enumeration of possible filtering mode when
+// retrieving GitHub issues, depending on whether they are pull requests.
+type GitHubItemFilter string
+
+const (
+	// GitHubItemFilterAll takes both issues and pull requests.
+	GitHubItemFilterAll GitHubItemFilter = ""
+
+	// GitHubItemFilterIssues filters issues which aren't pull requests.
+	GitHubItemFilterIssues GitHubItemFilter = "issues"
+
+	// GitHubItemFilterPulls filters pull requests.
+	GitHubItemFilterPulls GitHubItemFilter = "pulls"
+)
+
 const (
 	// DefaultFrom is the default starting number for syncing repository items.
 	DefaultFrom = 1
 
+	// DefaultNumRepoProcs is the default number of repositories synchronized
+	// in parallel.
+	DefaultNumRepoProcs = 4
+
 	// DefaultNumFetchProcs is the default number of goroutines fetching data
 	// from the GitHub API in parallel.
 	DefaultNumFetchProcs = 20
@@ -53,17 +76,24 @@ const (
 
 	// DefaultFilterMode is the default filtering mode for retrieving issues.
 	DefaultFilterMode = GitHubStateFilterOpened
+
+	// CheckpointSafetyMargin is subtracted from the start time of a job to
+	// compute the checkpoint it records, in order to account for the clock
+	// difference with GitHub.
+	CheckpointSafetyMargin = 5 * time.Minute
 )
 
 // DefaultSyncOptions is the default set of options for a synchronization job.
 var DefaultSyncOptions = syncOptions{
 	From:          DefaultFrom,
+	NumRepoProcs:  DefaultNumRepoProcs,
 	NumFetchProcs: DefaultNumFetchProcs,
 	NumIndexProcs: DefaultNumIndexProcs,
 	PerPage:       DefaultPerPage,
 	SleepPerPage:  DefaultSleepPerPage,
 	State:         GitHubStateFilterOpened,
 	Storage:       DefaultStorage,
+	Targets:       []SyncTarget{SyncTargetItems},
 }
 
 // syncCmd is a synchronization job.
@@ -71,12 +101,87 @@ type syncCmd struct {
 	blobStore storage.BlobStore
 	client    *github.Client
 	options   *syncOptions
-	toFetch   chan github.Issue
-	toIndex   chan githubIndexedItem
+	queue     *jobQueue
+	toIndex   chan syncJob
 	wgFetch   sync.WaitGroup
 	wgIndex   sync.WaitGroup
 }
 
+// repoSync is the synchronization of a single repository within a job.
+type repoSync struct {
+	*storage.Repository
+
+	// tracker keeps track of the progress of the listing of issues and pull
+	// requests.
+	tracker *pageTracker
+
+	// pending counts the jobs queued for the repository which aren't
+	// indexed yet.
+	pending sync.WaitGroup
+
+	mu      sync.Mutex
+	summary SyncSummary
+}
+
+// SyncSummary is the outcome of the synchronization of a repository.
+type SyncSummary struct {
+	// Repository is the given name of the repository.
+	Repository string
+
+	// Indexed is the number of documents stored for each type.
+	Indexed map[string]int
+
+	// Failed is the number of items which failed to be retrieved or stored.
+	Failed int
+
+	// Err is the last error which interrupted the retrieval of a target, in
+	// which case the checkpoint of the repository isn't moved forward.
+	Err error
+
+	// Duration is the time the synchronization of the repository took.
+	Duration time.Duration
+}
+
+// String returns a human readable description of the summary.
+func (s *SyncSummary) String() string {
+	types := make([]string, 0, len(s.Indexed))
+	for typ := range s.Indexed {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	counts := make([]string, 0, len(types))
+	for _, typ := range types {
+		counts = append(counts, fmt.Sprintf("%d %s", s.Indexed[typ], typ))
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "nothing")
+	}
+
+	out := fmt.Sprintf("%s: indexed %s in %s", s.Repository, strings.Join(counts, ", "), s.Duration)
+	if s.Failed > 0 {
+		out += fmt.Sprintf(" (%d failed)", s.Failed)
+	}
+	if s.Err != nil {
+		out += fmt.Sprintf(", interrupted by: %v", s.Err)
+	}
+	return out
+}
+
+// recordIndexed counts a document stored for the repository.
+func (r *repoSync) recordIndexed(typ string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.Indexed[typ]++
+}
+
+// recordFailed counts an item which failed to be retrieved or stored for the
+// repository.
+func (r *repoSync) recordFailed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.Failed++
+}
+
 // syncOptions is the set of options that can be configured for a
 // synchronization job.
 type syncOptions struct {
@@ -85,19 +190,39 @@ type syncOptions struct {
 	// number.
 	From int
 
+	// To is the optional index to stop syncing at (inclusive).
+	To int
+
+	// Numbers is an optional list of items to retrieve, instead of listing
+	// all items of the repository.
+	Numbers []int
+
+	// Only is a filter for retrieved items depending on whether they are pull
+	// requests.
+	Only GitHubItemFilter
+
+	// Since restricts the job to the items updated since then, regardless of
+	// the checkpoint of each repository.
+	Since time.Time
+
+	// NumRepoProcs is the number of repositories synchronized in parallel,
+	// each of them listing its data from the GitHub API in a goroutine.
+	NumRepoProcs int
+
 	// NumFetchProcs is the number of goroutines fetching GitHub data in
-	// parallel.
+	// parallel, shared by all repositories.
 	NumFetchProcs int
 
 	// NumIndexProcs is the number of goroutines storing data into the Elastic
-	// Search backend in parallel.
+	// Search backend in parallel, shared by all repositories.
 	NumIndexProcs int
 
 	// PerPage is the number of GitHub items to query per page.
 	PerPage int
 
-	// SleepPerPage is the number of seconds to sleep between each page queried
-	// to avoid triggering GitHub's abuse detection mechanism.
+	// SleepPerPage is an additional number of seconds to sleep between each
+	// page queried. Requests are already scheduled by the client according
+	// to the GitHub rate limit.
 	SleepPerPage int
 
 	// State is a filter for retrieved issues and pull requests.
@@ -105,6 +230,137 @@ type syncOptions struct {
 
 	// Storage is the type of Storage to Index into.
 	Storage storage.Storage
+
+	// Checkpoints is where the high-water mark of each repository (the time
+	// its last complete synchronization started) is persisted. Checkpoints
+	// are only recorded by jobs which retrieve items regardless of their
+	// state into the snapshot storage.
+	Checkpoints *state.File
+
+	// Incremental restricts the job to the items updated since the last
+	// checkpoint of each repository.
+	Incremental bool
+
+	// Resume continues the listing of each repository from the point where
+	// the previous job was interrupted, as recorded in Checkpoints.
+	Resume bool
+
+	// Targets is the list of data to retrieve for each repository.
+	Targets []SyncTarget
+
+	// Enrich is the list of additional data to retrieve for each pull
+	// request.
+	Enrich []Enrichment
+}
+
+// partial returns whether the job only retrieves a subset of the items, or
+// stores them elsewhere than in the snapshot storage, in which case it neither
+// records nor resumes any checkpoint or progress.
+func (o *syncOptions) partial() bool {
+	return o.To != 0 || len(o.Numbers) != 0 || o.Only != GitHubItemFilterAll || !o.Since.IsZero() || o.Storage != storage.StoreSnapshot
+}
+
+// wants returns whether the item matches the number range and the kind
+// filters of the job.
+func (o *syncOptions) wants(i *github.Issue) bool {
+	if o.To != 0 && *i.Number > o.To {
+		return false
+	}
+	switch o.Only {
+	case GitHubItemFilterIssues:
+		return i.PullRequestLinks == nil
+	case GitHubItemFilterPulls:
+		return i.PullRequestLinks != nil
+	}
+	return true
+}
+
+// hasTarget returns whether the job retrieves the specified target.
+func (o *syncOptions) hasTarget(target SyncTarget) bool {
+	for _, t := range o.Targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// syncCheckpoint is the persisted high-water mark of a repository: all of its
+// items updated before then were retrieved.
+type syncCheckpoint struct {
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func checkpointKey(r *storage.Repository) string {
+	return "sync." + r.GivenName
+}
+
+func progressKey(r *storage.Repository) string {
+	return "sync.progress." + r.GivenName
+}
+
+// resumePoint returns the progress of the interrupted job to resume for the
+// repository, or the zero progress to start from scratch.
+func (s *syncCmd) resumePoint(r *storage.Repository, since time.Time) syncProgress {
+	var p syncProgress
+	if !s.options.Resume || s.options.Checkpoints == nil {
+		return p
+	}
+	if s.options.partial() {
+		log.Warnf("not resuming the interrupted sync for %s with filtered items", r.PrettyName())
+		return p
+	}
+	if ok, err := s.options.Checkpoints.Get(progressKey(r), &p); err != nil {
+		log.Errorf("failed to load sync progress for %s: %v", r.PrettyName(), err)
+		return syncProgress{}
+	} else if !ok {
+		log.Infof("no interrupted sync to resume for %s", r.PrettyName())
+		return syncProgress{}
+	}
+	// Pages only match when listing the same items with the same page size.
+	if p.State != s.options.State || !p.Since.Equal(since) || p.PerPage != s.options.PerPage {
+		log.Warnf("ignoring interrupted sync for %s which used different options", r.PrettyName())
+		return syncProgress{}
+	}
+	log.Infof("resuming sync for %s after page %d (item #%d)", r.PrettyName(), p.Page, p.Number)
+	return p
+}
+
+// saveProgress records the progress of the job for the repository.
+func (s *syncCmd) saveProgress(r *storage.Repository, p syncProgress) {
+	if err := s.options.Checkpoints.Set(progressKey(r), &p); err != nil {
+		log.Errorf("failed to save sync progress for %s: %v", r.PrettyName(), err)
+	}
+}
+
+// checkpoint returns the time since which items should be retrieved for the
+// repository, or the zero time for a complete synchronization.
+func (s *syncCmd) checkpoint(r *storage.Repository) time.Time {
+	if !s.options.Since.IsZero() {
+		return s.options.Since
+	}
+	if !s.options.Incremental || s.options.Checkpoints == nil || s.options.State != GitHubStateFilterAll {
+		return time.Time{}
+	}
+	var c syncCheckpoint
+	if ok, err := s.options.Checkpoints.Get(checkpointKey(r), &c); err != nil {
+		log.Errorf("failed to load sync checkpoint for %s: %v", r.PrettyName(), err)
+	} else if !ok {
+		log.Infof("no sync checkpoint for %s: running a complete synchronization", r.PrettyName())
+	}
+	return c.UpdatedAt
+}
+
+// saveCheckpoint records the high-water mark for the repository. Partial jobs
+// don't record it: in particular, the next incremental job would skip the
+// items which jobs storing into another storage didn't store in the snapshot.
+func (s *syncCmd) saveCheckpoint(r *storage.Repository, updatedAt time.Time) {
+	if s.options.Checkpoints == nil || s.options.State != GitHubStateFilterAll || s.options.partial() {
+		return
+	}
+	if err := s.options.Checkpoints.Set(checkpointKey(r), &syncCheckpoint{UpdatedAt: updatedAt}); err != nil {
+		log.Errorf("failed to save sync checkpoint for %s: %v", r.PrettyName(), err)
+	}
 }
 
 // NewSyncCommand creates a default configured synchronization job.
@@ -121,8 +377,6 @@ func NewSyncCommandWithOptions(client *github.Client, blobStore storage.BlobStor
 		blobStore: blobStore,
 		client:    client,
 		options:   opt,
-		toFetch:   make(chan github.Issue, opt.NumFetchProcs),
-		toIndex:   make(chan githubIndexedItem, opt.NumIndexProcs),
 	}
 }
 
@@ -130,52 +384,193 @@ func NewSyncCommandWithOptions(client *github.Client, blobStore storage.BlobStor
 // options overrides any per-repository starting index.
 //
 // This function starts NumIndexProcs indexing goroutines and NumFetchProcs
-// fetching goroutines, but won't return until all job is done, or a fatal
-// error occurs.
+// fetching goroutines shared by all repositories, which are synchronized
+// NumRepoProcs at a time. The jobs of all repositories go through a single
+// queue which serves them in turn. It won't return until all job is done, and
+// returns the summary of each repository in the same order.
 //
 // Isolated errors (failure to retrieve a particular item, or failure to write
 // to the backend) will not interrupt the job. Only the inability to list items
-// from GitHub can interrupt prematurely (such as in case of rate limiting).
-func (s *syncCmd) Run(repos []*storage.Repository) {
+// from GitHub can interrupt prematurely the synchronization of a repository
+// (such as in case of rate limiting), without affecting the others.
+func (s *syncCmd) Run(repos []*storage.Repository) []*SyncSummary {
+	s.queue = newJobQueue(s.options.NumFetchProcs)
+	s.toIndex = make(chan syncJob, s.options.NumIndexProcs)
+	for i := 0; i != s.options.NumIndexProcs; i++ {
+		s.wgIndex.Add(1)
+		go s.indexingProc()
+	}
+	for i := 0; i != s.options.NumFetchProcs; i++ {
+		s.wgFetch.Add(1)
+		go s.fetchingProc()
+	}
+
+	// Repositories are picked in order by the NumRepoProcs goroutines.
+	numRepoProcs := s.options.NumRepoProcs
+	if numRepoProcs < 1 {
+		numRepoProcs = 1
+	}
+	toSync := make(chan *repoSync)
+	var wgRepos sync.WaitGroup
+	for i := 0; i != numRepoProcs; i++ {
+		wgRepos.Add(1)
+		go func() {
+			for r := range toSync {
+				s.syncRepository(r)
+			}
+			wgRepos.Done()
+		}()
+	}
+	summaries := make([]*SyncSummary, 0, len(repos))
 	for _, r := range repos {
-		for i := 0; i != s.options.NumIndexProcs; i++ {
-			s.wgIndex.Add(1)
-			go s.indexingProc(r)
+		rs := &repoSync{
+			Repository: r,
+			summary: SyncSummary{
+				Repository: r.GivenName,
+				Indexed:    make(map[string]int),
+			},
 		}
+		summaries = append(summaries, &rs.summary)
+		toSync <- rs
+	}
+	close(toSync)
+	wgRepos.Wait()
 
-		for i := 0; i != s.options.NumFetchProcs; i++ {
-			s.wgFetch.Add(1)
-			go s.fetchingProc(r)
-		}
+	// When all repositories are done, all jobs were processed.
+	s.queue.Close()
+	s.wgFetch.Wait()
+	close(s.toIndex)
+	s.wgIndex.Wait()
+
+	for _, summary := range summaries {
+		log.Warnf("sync summary for %s", summary)
+	}
+	return summaries
+}
 
-		// The command line `--from` option override the configuration defined
-		// repository settings.
-		from := s.options.From
-		if from == 0 {
-			from = r.RepositoryConfig.StartIndex
+// syncRepository retrieves the targets of the job for the repository, and
+// waits until all of its jobs are processed.
+func (s *syncCmd) syncRepository(r *repoSync) {
+	start := time.Now()
+	log.Infof("starting sync for %s", r.PrettyName())
+
+	// The command line `--from` option override the configuration defined
+	// repository settings.
+	from := s.options.From
+	if from == 0 {
+		from = r.RepositoryConfig.StartIndex
+	}
+	since := s.checkpoint(r.Repository)
+
+	// Progress is recorded as pages get fully indexed, which allows to
+	// resume an interrupted job. The progress records when the job started,
+	// which is where the checkpoint goes once the job is resumed and done.
+	resume := s.resumePoint(r.Repository, since)
+	if resume.StartedAt.IsZero() {
+		resume.StartedAt = start
+	}
+	var onProgress func(syncProgress)
+	if s.options.Checkpoints != nil && !s.options.partial() {
+		onProgress = func(p syncProgress) { s.saveProgress(r.Repository, p) }
+	}
+	r.tracker = newPageTracker(resume, onProgress)
+
+	var err error
+	if s.options.hasTarget(SyncTargetItems) && len(s.options.Numbers) != 0 {
+		if err = s.fetchRepositoryNumbers(r, s.options.Numbers); err != nil {
+			log.Errorf("error syncing repository %s issues: %v", r.PrettyName(), err)
 		}
-		if err := s.fetchRepositoryItems(r, from, s.options.SleepPerPage, s.options.State); err != nil {
+	} else if s.options.hasTarget(SyncTargetItems) {
+		if err = s.fetchRepositoryItems(r, from, since, resume, s.options.SleepPerPage, s.options.State); err != nil {
 			log.Errorf("error syncing repository %s issues: %v", r.PrettyName(), err)
 		}
+	}
 
-		// When fetchRepositoryItems is done, all data to fetch has been queued.
-		close(s.toFetch)
+	if s.options.hasTarget(SyncTargetComments) {
+		if cerr := s.fetchRepositoryComments(r, since); cerr != nil {
+			log.Errorf("error syncing repository %s comments: %v", r.PrettyName(), cerr)
+			err = cerr
+		}
+	}
+	if s.options.hasTarget(SyncTargetTimeline) {
+		if terr := s.fetchRepositoryTimeline(r, since); terr != nil {
+			log.Errorf("error syncing repository %s issue events: %v", r.PrettyName(), terr)
+			err = terr
+		}
+	}
+	if s.options.hasTarget(SyncTargetReleases) {
+		if rerr := s.fetchRepositoryReleases(r); rerr != nil {
+			log.Errorf("error syncing repository %s releases: %v", r.PrettyName(), rerr)
+			err = rerr
+		}
+	}
+	if s.options.hasTarget(SyncTargetWorkflows) {
+		if werr := s.fetchRepositoryWorkflowRuns(r, since); werr != nil {
+			log.Errorf("error syncing repository %s workflow runs: %v", r.PrettyName(), werr)
+			err = werr
+		}
+	}
+	if s.options.hasTarget(SyncTargetRepository) {
+		if rerr := s.fetchRepositoryObject(r); rerr != nil {
+			log.Errorf("error syncing repository %s: %v", r.PrettyName(), rerr)
+			err = rerr
+		}
+	}
+	for _, target := range []SyncTarget{SyncTargetMilestones, SyncTargetLabels} {
+		if !s.options.hasTarget(target) {
+			continue
+		}
+		if cerr := s.fetchRepositoryCatalog(r, target); cerr != nil {
+			log.Errorf("error syncing repository %s %s: %v", r.PrettyName(), target, cerr)
+			err = cerr
+		}
+	}
+	for _, target := range []SyncTarget{SyncTargetStargazers, SyncTargetForks} {
+		if !s.options.hasTarget(target) {
+			continue
+		}
+		if herr := s.fetchRepositoryHistory(r, target); herr != nil {
+			log.Errorf("error syncing repository %s %s: %v", r.PrettyName(), target, herr)
+			err = herr
+		}
+	}
 
-		// When the fetchingProc is done, all data to index has been queued.
-		s.wgFetch.Wait()
-		log.Warn("done fetching GitHub API data")
-		close(s.toIndex)
+	// All data to fetch has been queued: wait until it is indexed.
+	r.pending.Wait()
+	log.Infof("done syncing %s", r.PrettyName())
+
+	// Only move the checkpoint forward when all items were retrieved and
+	// indexed, in which case there is nothing left to resume. The progress
+	// goes past the items which failed: it is dropped as well when some did,
+	// so that the next job starts over from the previous checkpoint.
+	//
+	// The checkpoint is the time the job started rather than the most recent
+	// update time of the listed items: an item listed early in the job may be
+	// updated again before it ends.
+	r.mu.Lock()
+	failed := r.summary.Failed
+	r.mu.Unlock()
+	if err == nil {
+		if failed == 0 && s.options.hasTarget(SyncTargetItems) {
+			s.saveCheckpoint(r.Repository, resume.StartedAt.Add(-CheckpointSafetyMargin))
+		} else {
+			log.Warnf("%d items failed for %s: not moving the sync checkpoint", failed, r.PrettyName())
+		}
+		if s.options.Checkpoints != nil && !s.options.partial() {
+			s.options.Checkpoints.Delete(progressKey(r.Repository))
+		}
+	}
 
-		// Wait until indexing completes.
-		s.wgIndex.Wait()
-		log.Warn("done indexing documents in Elastic Search")
+	r.mu.Lock()
+	r.summary.Err = err
+	r.summary.Duration = time.Since(start)
+	r.mu.Unlock()
+}
 
-		// we've closed the channels, but if the repo array is
-		// larger than 1, we need fresh channels for the next
-		// iteration of the for loop
-		s.toFetch = make(chan github.Issue, s.options.NumFetchProcs)
-		s.toIndex = make(chan githubIndexedItem, s.options.NumIndexProcs)
-	}
+// enqueue queues a job of the repository for the shared workers.
+func (s *syncCmd) enqueue(j syncJob) {
+	j.repo.pending.Add(1)
+	s.queue.Push(j)
 }
 
 // fetchRepositoryItems queries the GitHub API for all issues and pull requests
@@ -193,18 +588,58 @@ func (s *syncCmd) Run(repos []*storage.Repository) {
 // some of which pull requests don't (in particular labels), but we still need
 // the information that are held by the pull request itself (such as additions
 // and deletions).
-func (s *syncCmd) fetchRepositoryItems(r *storage.Repository, from, sleepPerPage int, stateFilter GitHubStateFilter) error {
+//
+// When since is set, only the items updated since then are listed.
+//
+// Items outside of the number range or of the kind requested by the options
+// of the job are skipped. Listing by creation order stops past the To number.
+//
+// When resuming an interrupted job, the listing restarts from the last page
+// which was fully processed, and already processed items are skipped. Items
+// listed by update time are instead listed again from the most recent update
+// time which was fully processed.
+func (s *syncCmd) fetchRepositoryItems(r *repoSync, from int, since time.Time, resume syncProgress, sleepPerPage int, stateFilter GitHubStateFilter) error {
+	opts := &github.IssueListByRepoOptions{
+		Direction: "asc", // List by created date ascending
+		Sort:      "created",
+		State:     string(stateFilter),
+	}
+
+	// Items are numbered by creation order, which allows to skip pages when
+	// starting from a given number. This doesn't hold when listing by update
+	// time, and items below from have to be skipped one by one.
+	firstPage := from/s.options.PerPage + 1
+	if !since.IsZero() {
+		if resume.UpdatedAt.After(since) {
+			since = resume.UpdatedAt
+		}
+		log.Infof("retrieving items for %s updated since %s", r.PrettyName(), since.Format(time.RFC3339))
+		opts.Sort = "updated"
+		opts.Since = since
+		firstPage = 1
+		resume.Page, resume.Number = 0, 0
+	} else if resume.Page > 0 {
+		// Items may have been removed from earlier pages since the job was
+		// interrupted: start from the last fully processed page to make sure
+		// we don't skip anything.
+		firstPage = resume.Page
+	}
+
+	progress := syncProgress{
+		Number:    resume.Number,
+		UpdatedAt: resume.UpdatedAt,
+		State:     stateFilter,
+		Since:     since,
+		PerPage:   s.options.PerPage,
+		StartedAt: resume.StartedAt,
+	}
 	count := 0
-	for page := from/s.options.PerPage + 1; page != 0; {
-		iss, resp, err := s.client.Issues.ListByRepo(r.User, r.Repo, &github.IssueListByRepoOptions{
-			Direction: "asc", // List by created date ascending
-			Sort:      "created",
-			State:     string(stateFilter),
-			ListOptions: github.ListOptions{
-				Page:    page,
-				PerPage: 100,
-			},
-		})
+	for page := firstPage; page != 0; {
+		opts.ListOptions = github.ListOptions{
+			Page:    page,
+			PerPage: s.options.PerPage,
+		}
+		iss, resp, err := s.client.Issues.ListByRepo(r.User, r.Repo, opts)
 		if err != nil {
 			return err
 		}
@@ -212,12 +647,34 @@ func (s *syncCmd) fetchRepositoryItems(r *storage.Repository, from, sleepPerPage
 		count += len(iss)
 		log.Infof("retrieved %d items for %s (page %d)", count, r.PrettyName(), page)
 
-		// If the issue is really a pull request, fetch it as such.
+		queued := []github.Issue{}
 		for _, i := range iss {
-			if i.PullRequestLinks == nil {
-				s.toIndex <- githubIssue(i)
+			// Items are listed by creation order: there is nothing left to
+			// list past the To number.
+			if since.IsZero() && s.options.To != 0 && *i.Number > s.options.To {
+				resp.NextPage = 0
+			}
+			if i.UpdatedAt != nil && i.UpdatedAt.After(progress.UpdatedAt) {
+				progress.UpdatedAt = *i.UpdatedAt
+			}
+			if *i.Number <= resume.Number || (!since.IsZero() && *i.Number < from) || !s.options.wants(&i) {
+				continue
+			}
+			if *i.Number > progress.Number {
+				progress.Number = *i.Number
+			}
+			queued = append(queued, i)
+		}
+
+		// The page must be tracked before its items get processed.
+		r.tracker.Add(page, len(queued), progress)
+
+		// If the issue is really a pull request, fetch it as such.
+		for i := range queued {
+			if queued[i].PullRequestLinks == nil {
+				s.enqueue(syncJob{repo: r, item: githubIssue(queued[i]), page: page})
 			} else {
-				s.toFetch <- i
+				s.enqueue(syncJob{repo: r, issue: &queued[i], page: page})
 			}
 		}
 
@@ -229,45 +686,106 @@ func (s *syncCmd) fetchRepositoryItems(r *storage.Repository, from, sleepPerPage
 	return nil
 }
 
-// fetchingProc takes input from the toFetch channel and fetches additional
-// data for items were applicable. In particular, it gets the pull request
-// information for issues which are indeed pull requests.
-func (s *syncCmd) fetchingProc(r *storage.Repository) {
-	for i := range s.toFetch {
-		log.Debugf("fetching associated pull request for issue %d", *i.Number)
-		if item, err := pullRequestFromIssue(s.client, r, &i); err == nil {
-			s.toIndex <- item
+// fetchRepositoryNumbers queries the GitHub API for the specified issues and
+// pull requests of a repository. Failing to retrieve an item doesn't prevent
+// the others from being retrieved, and the last error is returned.
+func (s *syncCmd) fetchRepositoryNumbers(r *repoSync, numbers []int) error {
+	var lastErr error
+	for _, n := range numbers {
+		i, _, err := s.client.Issues.Get(r.User, r.Repo, n)
+		if err != nil {
+			lastErr = fmt.Errorf("retrieve item %d: %v", n, err)
+			log.Errorf("error syncing repository %s: %v", r.PrettyName(), lastErr)
+			continue
+		}
+		if !s.options.wants(i) {
+			log.Infof("skipping item %d of %s which doesn't match the filters", n, r.PrettyName())
+			continue
+		}
+		if i.PullRequestLinks == nil {
+			s.enqueue(syncJob{repo: r, item: githubIssue(*i)})
 		} else {
-			s.toIndex <- githubIssue(i)
-			log.Errorf("fail to retrieve pull request information for %d: %v", *i.Number, err)
+			s.enqueue(syncJob{repo: r, issue: i})
 		}
 	}
+	return lastErr
+}
+
+// fetchingProc takes jobs from the shared queue and fetches additional data
+// for items were applicable. In particular, it gets the pull request
+// information for issues which are indeed pull requests.
+func (s *syncCmd) fetchingProc() {
+	for {
+		j, ok := s.queue.Pop()
+		if !ok {
+			break
+		}
+		if i := j.issue; i != nil {
+			log.Debugf("fetching associated pull request for issue %d", *i.Number)
+			if item, err := pullRequestFromIssue(s.client, j.repo.Repository, i); err == nil {
+				// Failing to retrieve the additional data isn't a reason not
+				// to index the pull request.
+				if err := enrichPullRequest(s.client, j.repo.Repository, item, s.options.Enrich); err != nil {
+					log.Errorf("fail to enrich pull request %d: %v", *i.Number, err)
+				}
+				j.item = item
+			} else {
+				j.item = githubIssue(*i)
+				j.repo.recordFailed()
+				log.Errorf("fail to retrieve pull request information for %d: %v", *i.Number, err)
+			}
+		}
+		s.toIndex <- j
+	}
 	s.wgFetch.Done()
 }
 
 // indexingProc takes input from the toIndex channel and pushes the content to
 // the Elastic Search backend.
-func (s *syncCmd) indexingProc(r *storage.Repository) {
-	for i := range s.toIndex {
-		// We have to serialize back to JSON in order to transform the payload
-		// as we wish. This could be optimized out if we were to read the raw
-		// GitHub data rather than rely on the typed go-github package.
-		payload, err := json.Marshal(i)
-		if err != nil {
-			log.Errorf("error marshaling githubIndexedItem %q (%s): %v", i.ID(), i.Type(), err)
-			continue
-		}
-		// We create a blob from the payload, which essentially deserialized
-		// the object back from JSON...
-		b, err := blob.NewBlobFromPayload(i.Type(), i.ID(), payload)
-		if err != nil {
-			log.Errorf("creating blob from payload %q (%s): %v", i.ID(), i.Type(), err)
-			continue
+func (s *syncCmd) indexingProc() {
+	for j := range s.toIndex {
+		if s.indexItem(j.repo.Repository, j.item) {
+			j.repo.recordIndexed(j.item.Type())
+		} else {
+			j.repo.recordFailed()
 		}
-		// Persist the object in Elastic Search.
-		if err := s.blobStore.Store(s.options.Storage, r, b); err != nil {
-			log.Error(err)
+		if j.page != 0 {
+			j.repo.tracker.Done(j.page)
 		}
+		j.repo.pending.Done()
 	}
 	s.wgIndex.Done()
 }
+
+// indexItem pushes a single item to the Elastic Search backend, and returns
+// whether it succeeded. Errors are logged but otherwise ignored.
+func (s *syncCmd) indexItem(r *storage.Repository, i githubIndexedItem) bool {
+	// We have to serialize back to JSON in order to transform the payload
+	// as we wish. This could be optimized out if we were to read the raw
+	// GitHub data rather than rely on the typed go-github package.
+	payload, err := json.Marshal(i)
+	if err != nil {
+		log.Errorf("error marshaling githubIndexedItem %q (%s): %v", i.ID(), i.Type(), err)
+		return false
+	}
+	// We create a blob from the payload, which essentially deserialized
+	// the object back from JSON...
+	b, err := blob.NewBlobFromPayload(i.Type(), i.ID(), payload)
+	if err != nil {
+		log.Errorf("creating blob from payload %q (%s): %v", i.ID(), i.Type(), err)
+		return false
+	}
+	// Past events are stored as live events at the time they happened,
+	// regardless of the storage of the job.
+	storageType := s.options.Storage
+	if e, ok := i.(*githubEventItem); ok {
+		b.Timestamp = e.timestamp
+		storageType = storage.StoreLiveEvent
+	}
+	// Persist the object in Elastic Search.
+	if err := s.blobStore.Store(storageType, r, b); err != nil {
+		log.Error(err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,378 @@
+package github
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"cmd/vossibility-collector/blob"
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/state"
+	"cmd/vossibility-collector/storage"
+)
+
+var testRepository = storage.Repository{
+	RepositoryConfig: config.RepositoryConfig{
+		User: "icecrime",
+		Repo: "repo",
+	},
+	GivenName: "testrepo",
+}
+
+type testBlobStore struct {
+	sync.Mutex
+	ids      []string
+	blobs    []*blob.Blob
+	storages []storage.Storage
+
+	// failing holds the IDs of the blobs which fail to be stored.
+	failing map[string]bool
+}
+
+func (t *testBlobStore) Store(s storage.Storage, r *storage.Repository, b *blob.Blob) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.failing[b.ID] {
+		return errors.New("store failure")
+	}
+	t.ids = append(t.ids, b.ID)
+	t.blobs = append(t.blobs, b)
+	t.storages = append(t.storages, s)
+	return nil
+}
+
+// simulateIssuesAPI serves the issues from the list, and records the query
+// of each request.
+func simulateIssuesAPI(issues []map[string]interface{}) (*httptest.Server, *[]url.Values) {
+	var queries []url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/icecrime/repo/issues", func(w http.ResponseWriter, req *http.Request) {
+		queries = append(queries, req.URL.Query())
+		json.NewEncoder(w).Encode(issues)
+	})
+	return httptest.NewServer(mux), &queries
+}
+
+func TestSyncCheckpoint(t *testing.T) {
+	issues := []map[string]interface{}{
+		{"number": 1, "updated_at": "2016-01-02T00:00:00Z"},
+		{"number": 2, "updated_at": "2016-01-03T00:00:00Z"},
+	}
+	srv, queries := simulateIssuesAPI(issues)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	checkpoints, _ := state.Open("")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.State = GitHubStateFilterAll
+	options.Checkpoints = checkpoints
+	options.Incremental = true
+
+	// The first synchronization is complete, and records the time it
+	// started as the checkpoint (minus the safety margin), as items may be
+	// updated while it runs.
+	store := &testBlobStore{}
+	before := time.Now()
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if len(store.ids) != 2 {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+	if q := (*queries)[0]; q.Get("since") != "" || q.Get("sort") != "created" {
+		t.Fatalf("unexpected query for complete synchronization %v", q)
+	}
+	var c syncCheckpoint
+	if ok, _ := checkpoints.Get(checkpointKey(&testRepository), &c); !ok {
+		t.Fatal("missing checkpoint after synchronization")
+	} else if c.UpdatedAt.Before(before.Add(-CheckpointSafetyMargin)) || c.UpdatedAt.After(time.Now().Add(-CheckpointSafetyMargin)) {
+		t.Fatalf("unexpected checkpoint %v for a synchronization started at %v", c.UpdatedAt, before)
+	}
+
+	// The next synchronization starts from the checkpoint.
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if q := (*queries)[1]; q.Get("since") != c.UpdatedAt.UTC().Format(time.RFC3339) || q.Get("sort") != "updated" {
+		t.Fatalf("unexpected query for incremental synchronization %v", q)
+	}
+
+	// A full synchronization ignores the checkpoint.
+	options.Incremental = false
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if q := (*queries)[2]; q.Get("since") != "" {
+		t.Fatalf("unexpected query for full synchronization %v", q)
+	}
+}
+
+func TestSyncCheckpointFailedItems(t *testing.T) {
+	issues := []map[string]interface{}{
+		{"number": 1, "updated_at": "2016-01-02T00:00:00Z"},
+		{"number": 2, "updated_at": "2016-01-03T00:00:00Z"},
+	}
+	srv, _ := simulateIssuesAPI(issues)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	checkpoints, _ := state.Open("")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.State = GitHubStateFilterAll
+	options.Checkpoints = checkpoints
+	options.Incremental = true
+
+	// The checkpoint isn't moved past an item which failed to be indexed.
+	store := &testBlobStore{failing: map[string]bool{"1": true}}
+	summaries := NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if summaries[0].Failed != 1 {
+		t.Fatalf("unexpected summary %s", summaries[0])
+	}
+	if ok, _ := checkpoints.Get(checkpointKey(&testRepository), &syncCheckpoint{}); ok {
+		t.Fatal("unexpected checkpoint after failed items")
+	}
+	if ok, _ := checkpoints.Get(progressKey(&testRepository), &syncProgress{}); ok {
+		t.Fatal("unexpected progress after failed items")
+	}
+}
+
+func TestSyncCheckpointStorage(t *testing.T) {
+	issues := []map[string]interface{}{
+		{"number": 1, "updated_at": "2016-01-02T00:00:00Z"},
+	}
+	srv, _ := simulateIssuesAPI(issues)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	checkpoints, _ := state.Open("")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.State = GitHubStateFilterAll
+	options.Storage = storage.StoreCurrentState
+	options.Checkpoints = checkpoints
+	options.Incremental = true
+
+	// Items stored in the state index aren't in the snapshot index: the
+	// checkpoint of the snapshot synchronizations is left alone.
+	NewSyncCommandWithOptions(client, &testBlobStore{}, &options).Run([]*storage.Repository{&testRepository})
+	if ok, _ := checkpoints.Get(checkpointKey(&testRepository), &syncCheckpoint{}); ok {
+		t.Fatal("unexpected checkpoint after synchronization into the state storage")
+	}
+}
+
+func TestSyncResume(t *testing.T) {
+	issues := []map[string]interface{}{
+		{"number": 1, "updated_at": "2016-01-02T00:00:00Z"},
+		{"number": 2, "updated_at": "2016-01-03T00:00:00Z"},
+		{"number": 3, "updated_at": "2016-01-01T00:00:00Z"},
+	}
+	srv, queries := simulateIssuesAPI(issues)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	checkpoints, _ := state.Open("")
+	startedAt := time.Date(2016, 1, 5, 0, 0, 0, 0, time.UTC)
+	checkpoints.Set(progressKey(&testRepository), &syncProgress{
+		Page:      2,
+		Number:    2,
+		State:     GitHubStateFilterAll,
+		PerPage:   DefaultPerPage,
+		StartedAt: startedAt,
+	})
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.State = GitHubStateFilterAll
+	options.Checkpoints = checkpoints
+	options.Resume = true
+
+	// The listing restarts from the last fully processed page, and skips the
+	// items which were already processed.
+	store := &testBlobStore{}
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if q := (*queries)[0]; q.Get("page") != "2" {
+		t.Fatalf("unexpected query for resumed synchronization %v", q)
+	}
+	if len(store.ids) != 1 || store.ids[0] != "3" {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+
+	// There is nothing left to resume once the synchronization completes,
+	// and the checkpoint is the time the interrupted job started.
+	if ok, _ := checkpoints.Get(progressKey(&testRepository), &syncProgress{}); ok {
+		t.Fatal("unexpected progress after complete synchronization")
+	}
+	var c syncCheckpoint
+	if ok, _ := checkpoints.Get(checkpointKey(&testRepository), &c); !ok {
+		t.Fatal("missing checkpoint after synchronization")
+	} else if expected := startedAt.Add(-CheckpointSafetyMargin); !c.UpdatedAt.Equal(expected) {
+		t.Fatalf("unexpected checkpoint %v, expected %v", c.UpdatedAt, expected)
+	}
+}
+
+func TestSyncResumeOptions(t *testing.T) {
+	issues := []map[string]interface{}{
+		{"number": 1, "updated_at": "2016-01-02T00:00:00Z"},
+		{"number": 2, "updated_at": "2016-01-03T00:00:00Z"},
+	}
+	srv, queries := simulateIssuesAPI(issues)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	checkpoints, _ := state.Open("")
+	interrupted := &syncProgress{
+		Page:    2,
+		Number:  2,
+		State:   GitHubStateFilterAll,
+		PerPage: 50,
+	}
+	checkpoints.Set(progressKey(&testRepository), interrupted)
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.State = GitHubStateFilterAll
+	options.Checkpoints = checkpoints
+	options.Resume = true
+
+	// Pages of a different size don't hold the same items: the listing
+	// starts over.
+	store := &testBlobStore{}
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if q := (*queries)[0]; q.Get("page") != "" && q.Get("page") != "1" {
+		t.Fatalf("unexpected query for synchronization with another page size %v", q)
+	}
+	if len(store.ids) != 2 {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+
+	// Items stored elsewhere than in the snapshot storage neither resume
+	// nor record any progress.
+	checkpoints.Set(progressKey(&testRepository), interrupted)
+	options.PerPage = 50
+	options.Storage = storage.StoreCurrentState
+	store = &testBlobStore{}
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if q := (*queries)[1]; q.Get("page") != "" && q.Get("page") != "1" {
+		t.Fatalf("unexpected query for synchronization into the state storage %v", q)
+	}
+	var p syncProgress
+	if ok, _ := checkpoints.Get(progressKey(&testRepository), &p); !ok || p != *interrupted {
+		t.Fatalf("unexpected progress %v after synchronization into the state storage", p)
+	}
+}
+
+func TestSyncRepositoriesIsolation(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/icecrime/repo/issues", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`[{"number": 1, "updated_at": "2016-01-02T00:00:00Z"}, {"number": 2, "updated_at": "2016-01-03T00:00:00Z"}]`))
+	})
+	mux.HandleFunc("/repos/icecrime/broken/issues", func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, `{"message": "Server Error"}`, http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	checkpoints, _ := state.Open("")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 2, 2
+	options.State = GitHubStateFilterAll
+	options.Checkpoints = checkpoints
+
+	broken := testRepository
+	broken.GivenName, broken.Repo = "broken", "broken"
+	store := &testBlobStore{}
+	summaries := NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&broken, &testRepository})
+
+	// The failure of a repository doesn't prevent the others from being
+	// synchronized, nor from recording their checkpoint.
+	if len(summaries) != 2 {
+		t.Fatalf("unexpected summaries %v", summaries)
+	}
+	if s := summaries[0]; s.Repository != "broken" || s.Err == nil || len(s.Indexed) != 0 {
+		t.Fatalf("unexpected summary for failed repository: %s", s)
+	}
+	if s := summaries[1]; s.Repository != "testrepo" || s.Err != nil || s.Indexed[config.GitHubTypeIssue] != 2 {
+		t.Fatalf("unexpected summary for repository: %s", s)
+	}
+	if ok, _ := checkpoints.Get(checkpointKey(&broken), &syncCheckpoint{}); ok {
+		t.Fatal("unexpected checkpoint for failed repository")
+	}
+	if ok, _ := checkpoints.Get(checkpointKey(&testRepository), &syncCheckpoint{}); !ok {
+		t.Fatal("missing checkpoint after synchronization")
+	}
+}
+
+func TestSyncFilters(t *testing.T) {
+	issues := []map[string]interface{}{
+		{"number": 1, "updated_at": "2016-01-02T00:00:00Z"},
+		{"number": 2, "updated_at": "2016-01-03T00:00:00Z", "pull_request": map[string]interface{}{"url": "https://api.github.com/repos/icecrime/repo/pulls/2"}},
+		{"number": 3, "updated_at": "2016-01-04T00:00:00Z"},
+	}
+	srv, queries := simulateIssuesAPI(issues)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	checkpoints, _ := state.Open("")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.State = GitHubStateFilterAll
+	options.Checkpoints = checkpoints
+	options.PerPage = 50
+	options.To = 2
+	options.Only = GitHubItemFilterIssues
+
+	// Items outside of the range or of the requested kind are skipped, and
+	// the partial synchronization doesn't record any checkpoint.
+	store := &testBlobStore{}
+	NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if len(store.ids) != 1 || store.ids[0] != "1" {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+	if q := (*queries)[0]; q.Get("per_page") != "50" {
+		t.Fatalf("unexpected page size in query %v", q)
+	}
+	if ok, _ := checkpoints.Get(checkpointKey(&testRepository), &syncCheckpoint{}); ok {
+		t.Fatal("unexpected checkpoint after partial synchronization")
+	}
+}
+
+func TestSyncNumbers(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/icecrime/repo/issues/3", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"number": 3, "updated_at": "2016-01-04T00:00:00Z"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	options.Numbers = []int{3, 4}
+
+	// An item which fails to be retrieved doesn't prevent the others from
+	// being synchronized.
+	store := &testBlobStore{}
+	summaries := NewSyncCommandWithOptions(client, store, &options).Run([]*storage.Repository{&testRepository})
+	if len(store.ids) != 1 || store.ids[0] != "3" {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+	if summaries[0].Err == nil {
+		t.Fatal("expected error for missing item")
+	}
+}
@@ -0,0 +1,291 @@
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/storage"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// SyncTarget is a kind of repository data retrieved by a synchronization job.
+type SyncTarget string
+
+const (
+	// SyncTargetItems retrieves the issues and pull requests.
+	SyncTargetItems SyncTarget = "items"
+
+	// The other targets, except for stargazers and forks, are only retrieved
+	// for repositories whose event set has the snapshot transformation of
+	// the data: storing untransformed data would pollute the indices.
+
+	// SyncTargetComments retrieves the issue comments and the pull request
+	// review comments.
+	SyncTargetComments SyncTarget = "comments"
+
+	// SyncTargetTimeline retrieves the events of issues and pull requests
+	// (such as labeled, assigned, or closed).
+	SyncTargetTimeline SyncTarget = "timeline"
+
+	// SyncTargetStargazers and SyncTargetForks retrieve the stargazers and
+	// the forks of the repository, which are stored as live events at the
+	// time they happened.
+	SyncTargetStargazers SyncTarget = "stargazers"
+	SyncTargetForks      SyncTarget = "forks"
+
+	// SyncTargetReleases retrieves the releases (along with their assets) and
+	// the tags of the repository.
+	SyncTargetReleases SyncTarget = "releases"
+
+	// SyncTargetMilestones and SyncTargetLabels retrieve the milestones and
+	// the labels of the repository.
+	SyncTargetMilestones SyncTarget = "milestones"
+	SyncTargetLabels     SyncTarget = "labels"
+
+	// SyncTargetWorkflows retrieves the GitHub Actions workflow runs.
+	SyncTargetWorkflows SyncTarget = "workflows"
+
+	// SyncTargetRepository retrieves the repository itself, which provides a
+	// time series of its metrics (such as its number of stargazers) when
+	// stored in the rotating state index.
+	SyncTargetRepository SyncTarget = "repository"
+)
+
+// syncTargets is the list of all known synchronization targets.
+var syncTargets = []SyncTarget{
+	SyncTargetItems,
+	SyncTargetComments,
+	SyncTargetTimeline,
+	SyncTargetStargazers,
+	SyncTargetForks,
+	SyncTargetReleases,
+	SyncTargetMilestones,
+	SyncTargetLabels,
+	SyncTargetWorkflows,
+	SyncTargetRepository,
+}
+
+// ParseSyncTargets parses a comma-separated list of synchronization targets.
+func ParseSyncTargets(s string) ([]SyncTarget, error) {
+	var targets []SyncTarget
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, t := range syncTargets {
+			if string(t) == name {
+				targets = append(targets, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown sync target %q", name)
+		}
+	}
+	return targets, nil
+}
+
+// hasTransformation returns whether the event set of the repository has the
+// snapshot transformation for the data, and logs it otherwise.
+func hasTransformation(r *repoSync, snapshotType, what string) bool {
+	if !r.EventSet.Contains(snapshotType) {
+		log.Infof("repository %s has no %q transformation: not retrieving %s", r.PrettyName(), snapshotType, what)
+		return false
+	}
+	return true
+}
+
+// fetchPaged retrieves all pages of the indexer, and queues the items for
+// indexing. Any failure to fetch a page interrupts the process and returns the
+// error.
+func (s *syncCmd) fetchPaged(r *repoSync, what string, indexer githubPagedIndexer) error {
+	count := 0
+	for page := 1; page != 0; {
+		items, resp, err := indexer(page)
+		if err != nil {
+			return err
+		}
+
+		count += len(items)
+		log.Infof("retrieved %d %s for %s (page %d)", count, what, r.PrettyName(), page)
+		for _, i := range items {
+			s.enqueue(syncJob{repo: r, item: i})
+		}
+
+		page = resp.NextPage
+		if s.options.SleepPerPage > 0 {
+			time.Sleep(time.Duration(s.options.SleepPerPage) * time.Second)
+		}
+	}
+	return nil
+}
+
+// fetchRepositoryComments queries the GitHub API for all issue comments and
+// pull request review comments of a repository. When since is set, only the
+// comments updated since then are listed.
+func (s *syncCmd) fetchRepositoryComments(r *repoSync, since time.Time) error {
+	params := url.Values{}
+	params.Set("sort", "created")
+	params.Set("direction", "asc")
+	if !since.IsZero() {
+		params.Set("since", since.Format(time.RFC3339))
+	}
+
+	if hasTransformation(r, config.SnapshotIssueCommentType, "issue comments") {
+		issueComments := fmt.Sprintf("repos/%s/%s/issues/comments", r.User, r.Repo)
+		if err := s.fetchPaged(r, "issue comments", rawListIndexer(s.client, config.GitHubTypeIssueComment, issueComments, params, s.options.PerPage)); err != nil {
+			return err
+		}
+	}
+	if !hasTransformation(r, config.SnapshotReviewCommentType, "review comments") {
+		return nil
+	}
+	reviewComments := fmt.Sprintf("repos/%s/%s/pulls/comments", r.User, r.Repo)
+	return s.fetchPaged(r, "review comments", rawListIndexer(s.client, config.GitHubTypeReviewComment, reviewComments, params, s.options.PerPage))
+}
+
+// fetchRepositoryTimeline queries the GitHub API for all events of the issues
+// and pull requests of a repository. When since is set, only the events
+// created since then are listed.
+func (s *syncCmd) fetchRepositoryTimeline(r *repoSync, since time.Time) error {
+	if !hasTransformation(r, config.SnapshotIssueEventType, "issue events") {
+		return nil
+	}
+	return s.fetchPaged(r, "issue events", timelineIndexer(s.client, r.Repository, since, s.options.PerPage))
+}
+
+// timelineIndexer returns a githubPagedIndexer listing the issue events of
+// the repository. The API has no way to filter events by date, but lists the
+// most recent first: the listing is interrupted at the first event created
+// before since.
+func timelineIndexer(client *github.Client, r *storage.Repository, since time.Time, perPage int) githubPagedIndexer {
+	path := fmt.Sprintf("repos/%s/%s/issues/events", r.User, r.Repo)
+	return func(page int) ([]githubIndexedItem, *github.Response, error) {
+		objects, resp, err := listRaw(client, path, nil, page, perPage)
+		if err != nil {
+			return nil, resp, err
+		}
+		if !since.IsZero() {
+			for i, o := range objects {
+				var v struct {
+					CreatedAt time.Time `json:"created_at"`
+				}
+				if err := json.Unmarshal(o, &v); err != nil {
+					return nil, resp, err
+				}
+				if v.CreatedAt.Before(since) {
+					objects, resp.NextPage = objects[:i], 0
+					break
+				}
+			}
+		}
+		items, err := rawItems(config.GitHubTypeIssueEvent, objects)
+		return items, resp, err
+	}
+}
+
+// fetchRepositoryReleases queries the GitHub API for all releases and tags of
+// a repository. Tags have no identifier, and are identified by their name.
+func (s *syncCmd) fetchRepositoryReleases(r *repoSync) error {
+	if hasTransformation(r, config.SnapshotReleaseType, "releases") {
+		releases := fmt.Sprintf("repos/%s/%s/releases", r.User, r.Repo)
+		if err := s.fetchPaged(r, "releases", rawListIndexer(s.client, config.GitHubTypeRelease, releases, nil, s.options.PerPage)); err != nil {
+			return err
+		}
+	}
+	if !hasTransformation(r, config.SnapshotTagType, "tags") {
+		return nil
+	}
+	tags := fmt.Sprintf("repos/%s/%s/tags", r.User, r.Repo)
+	return s.fetchPaged(r, "tags", func(page int) ([]githubIndexedItem, *github.Response, error) {
+		objects, resp, err := listRaw(s.client, tags, nil, page, s.options.PerPage)
+		if err != nil {
+			return nil, resp, err
+		}
+		items, err := rawItemsByKey(config.GitHubTypeTag, "name", objects)
+		return items, resp, err
+	})
+}
+
+// fetchRepositoryCatalog queries the GitHub API for all milestones or labels
+// of a repository.
+func (s *syncCmd) fetchRepositoryCatalog(r *repoSync, target SyncTarget) error {
+	typ, snapshotType, path := config.GitHubTypeMilestone, config.SnapshotMilestoneType, "milestones"
+	if target == SyncTargetLabels {
+		typ, snapshotType, path = config.GitHubTypeLabel, config.SnapshotLabelType, "labels"
+	}
+	if !hasTransformation(r, snapshotType, string(target)) {
+		return nil
+	}
+
+	// Closed milestones are part of the catalog.
+	params := url.Values{}
+	if target == SyncTargetMilestones {
+		params.Set("state", string(GitHubStateFilterAll))
+	}
+	path = fmt.Sprintf("repos/%s/%s/%s", r.User, r.Repo, path)
+	return s.fetchPaged(r, string(target), rawListIndexer(s.client, typ, path, params, s.options.PerPage))
+}
+
+// fetchRepositoryWorkflowRuns queries the GitHub API for all workflow runs of
+// a repository. When since is set, only the runs created since then are
+// listed.
+func (s *syncCmd) fetchRepositoryWorkflowRuns(r *repoSync, since time.Time) error {
+	if !hasTransformation(r, config.SnapshotWorkflowRunType, "workflow runs") {
+		return nil
+	}
+	params := url.Values{}
+	if !since.IsZero() {
+		params.Set("created", ">="+since.Format(time.RFC3339))
+	}
+	path := fmt.Sprintf("repos/%s/%s/actions/runs", r.User, r.Repo)
+	return s.fetchPaged(r, "workflow runs", func(page int) ([]githubIndexedItem, *github.Response, error) {
+		objects, resp, err := listRawField(s.client, path, "workflow_runs", params, page, s.options.PerPage)
+		if err != nil {
+			return nil, resp, err
+		}
+		items, err := rawItems(config.GitHubTypeWorkflowRun, objects)
+		return items, resp, err
+	})
+}
+
+// fetchRepositoryObject queries the GitHub API for the repository itself. The
+// time of the synchronization is added to the object as the "synced_at"
+// attribute.
+func (s *syncCmd) fetchRepositoryObject(r *repoSync) error {
+	if !hasTransformation(r, config.SnapshotRepositoryType, "the repository") {
+		return nil
+	}
+
+	req, err := s.client.NewRequest("GET", fmt.Sprintf("repos/%s/%s", r.User, r.Repo), nil)
+	if err != nil {
+		return err
+	}
+	var repo map[string]json.RawMessage
+	if _, err := s.client.Do(req, &repo); err != nil {
+		return err
+	}
+	if repo["synced_at"], err = json.Marshal(time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(repo)
+	if err != nil {
+		return err
+	}
+
+	items, err := rawItems(config.GitHubTypeRepository, []json.RawMessage{data})
+	if err != nil {
+		return err
+	}
+	s.enqueue(syncJob{repo: r, item: items[0]})
+	return nil
+}
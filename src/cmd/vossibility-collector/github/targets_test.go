@@ -0,0 +1,270 @@
+package github
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/state"
+	"cmd/vossibility-collector/storage"
+	"cmd/vossibility-collector/transformation"
+)
+
+func TestParseSyncTargets(t *testing.T) {
+	targets, err := ParseSyncTargets("items, comments,")
+	if err != nil {
+		t.Fatalf("unexpected error parsing targets: %v", err)
+	}
+	if len(targets) != 2 || targets[0] != SyncTargetItems || targets[1] != SyncTargetComments {
+		t.Fatalf("unexpected targets %v", targets)
+	}
+
+	if _, err := ParseSyncTargets("items,invalid"); err == nil {
+		t.Fatal("expected error for unknown target")
+	}
+}
+
+// newTestSyncCommand creates a synchronization job with a single fetching and
+// indexing goroutine, against a test server serving the mux. Items are stored
+// in the returned store, and the server must be closed by the caller.
+func newTestSyncCommand(mux *http.ServeMux) (*syncCmd, *testBlobStore, *httptest.Server) {
+	srv := httptest.NewServer(mux)
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	options := DefaultSyncOptions
+	options.NumFetchProcs, options.NumIndexProcs = 1, 1
+	store := &testBlobStore{}
+	return NewSyncCommandWithOptions(client, store, &options), store, srv
+}
+
+// newTestEventSet returns an event set with the specified transformations.
+func newTestEventSet(types ...string) storage.EventSet {
+	e := storage.EventSet{}
+	for _, typ := range types {
+		e[typ] = transformation.NewTransformation()
+	}
+	return e
+}
+
+func TestSyncTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []SyncTarget
+
+		// incremental synchronizations start from a checkpoint on
+		// 2016-01-03, and eventSet replaces the one of the repository.
+		incremental bool
+		eventSet    storage.EventSet
+
+		// responses are the bodies served for each path, which link to a
+		// next page when paginated is set.
+		responses map[string]string
+		paginated bool
+
+		// expected lists the type and ID of the stored items, and check
+		// verifies the requests made for each path and the stored items.
+		expected []string
+		check    func(t *testing.T, requests map[string][]url.Values, store *testBlobStore)
+	}{
+		{
+			// Comments are listed since the checkpoint, and indexed by id.
+			name:        "comments",
+			targets:     []SyncTarget{SyncTargetComments},
+			incremental: true,
+			eventSet:    newTestEventSet(config.SnapshotIssueCommentType, config.SnapshotReviewCommentType),
+			responses: map[string]string{
+				"/repos/icecrime/repo/issues/comments": `[{"id": 10, "body": "first"}, {"id": 11, "body": "second"}]`,
+				"/repos/icecrime/repo/pulls/comments":  `[{"id": 20, "path": "README.md"}]`,
+			},
+			expected: []string{"issue_comment/10", "issue_comment/11", "pull_request_review_comment/20"},
+			check: func(t *testing.T, requests map[string][]url.Values, store *testBlobStore) {
+				if len(requests) != 2 {
+					t.Fatalf("unexpected requests %v", requests)
+				}
+				for path, queries := range requests {
+					if len(queries) != 1 || queries[0].Get("since") != "2016-01-03T00:00:00Z" {
+						t.Fatalf("unexpected queries %v for %s", queries, path)
+					}
+				}
+			},
+		},
+		{
+			// Only the data which has a snapshot transformation is
+			// retrieved.
+			name:     "transformations",
+			targets:  []SyncTarget{SyncTargetComments, SyncTargetTimeline, SyncTargetReleases, SyncTargetWorkflows},
+			eventSet: newTestEventSet(config.SnapshotReviewCommentType),
+			responses: map[string]string{
+				"/repos/icecrime/repo/issues/comments": `[{"id": 10, "body": "first"}]`,
+				"/repos/icecrime/repo/pulls/comments":  `[{"id": 20, "path": "README.md"}]`,
+				"/repos/icecrime/repo/issues/events":   `[{"id": 1, "event": "closed", "created_at": "2016-01-04T00:00:00Z"}]`,
+				"/repos/icecrime/repo/releases":        `[{"id": 1234567890, "tag_name": "v1.0"}]`,
+				"/repos/icecrime/repo/tags":            `[{"name": "v1.0", "commit": {"sha": "abcdef"}}]`,
+				"/repos/icecrime/repo/actions/runs":    `{"total_count": 1, "workflow_runs": [{"id": 30433642}]}`,
+			},
+			expected: []string{"pull_request_review_comment/20"},
+			check: func(t *testing.T, requests map[string][]url.Values, store *testBlobStore) {
+				if len(requests) != 1 {
+					t.Fatalf("unexpected requests %v", requests)
+				}
+			},
+		},
+		{
+			// Events are listed most recent first, and the listing stops at
+			// the first event created before the checkpoint.
+			name:        "timeline",
+			targets:     []SyncTarget{SyncTargetTimeline},
+			incremental: true,
+			eventSet:    newTestEventSet(config.SnapshotIssueEventType),
+			responses: map[string]string{
+				"/repos/icecrime/repo/issues/events": `[
+					{"id": 3, "event": "closed", "created_at": "2016-01-04T00:00:00Z"},
+					{"id": 2, "event": "labeled", "created_at": "2016-01-03T00:00:00Z"},
+					{"id": 1, "event": "assigned", "created_at": "2016-01-02T00:00:00Z"}
+				]`,
+			},
+			paginated: true,
+			expected:  []string{"issue_event/2", "issue_event/3"},
+			check: func(t *testing.T, requests map[string][]url.Values, store *testBlobStore) {
+				if pages := requests["/repos/icecrime/repo/issues/events"]; len(pages) != 1 {
+					t.Fatalf("unexpected pages %v, expected a single one", pages)
+				}
+			},
+		},
+		{
+			// Releases are identified by their id, and tags by their name.
+			name:     "releases",
+			targets:  []SyncTarget{SyncTargetReleases},
+			eventSet: newTestEventSet(config.SnapshotReleaseType, config.SnapshotTagType),
+			responses: map[string]string{
+				"/repos/icecrime/repo/releases": `[{"id": 1234567890, "tag_name": "v1.0", "assets": [{"name": "binary", "download_count": 42}]}]`,
+				"/repos/icecrime/repo/tags":     `[{"name": "v1.0", "commit": {"sha": "abcdef"}}]`,
+			},
+			expected: []string{"release/1234567890", "tag/v1.0"},
+		},
+		{
+			// Only the labels are retrieved when the event set lacks the
+			// milestones snapshot transformation.
+			name:     "labels",
+			targets:  []SyncTarget{SyncTargetMilestones, SyncTargetLabels},
+			eventSet: newTestEventSet(config.SnapshotLabelType),
+			responses: map[string]string{
+				"/repos/icecrime/repo/milestones": `[{"id": 1, "title": "1.0", "due_on": "2016-02-01T00:00:00Z"}]`,
+				"/repos/icecrime/repo/labels":     `[{"id": 2, "name": "bug", "color": "fc2929"}]`,
+			},
+			expected: []string{"label/2"},
+			check: func(t *testing.T, requests map[string][]url.Values, store *testBlobStore) {
+				if queries := requests["/repos/icecrime/repo/milestones"]; len(queries) != 0 {
+					t.Fatal("unexpected milestones request")
+				}
+			},
+		},
+		{
+			// Closed milestones are retrieved as well.
+			name:     "milestones",
+			targets:  []SyncTarget{SyncTargetMilestones, SyncTargetLabels},
+			eventSet: newTestEventSet(config.SnapshotLabelType, config.SnapshotMilestoneType),
+			responses: map[string]string{
+				"/repos/icecrime/repo/milestones": `[{"id": 1, "title": "1.0", "due_on": "2016-02-01T00:00:00Z"}]`,
+				"/repos/icecrime/repo/labels":     `[{"id": 2, "name": "bug", "color": "fc2929"}]`,
+			},
+			expected: []string{"label/2", "milestone/1"},
+			check: func(t *testing.T, requests map[string][]url.Values, store *testBlobStore) {
+				if queries := requests["/repos/icecrime/repo/milestones"]; len(queries) != 1 || queries[0].Get("state") != "all" {
+					t.Fatalf("unexpected milestones queries %v", queries)
+				}
+			},
+		},
+		{
+			// Workflow runs are listed since the checkpoint.
+			name:        "workflows",
+			targets:     []SyncTarget{SyncTargetWorkflows},
+			incremental: true,
+			eventSet:    newTestEventSet(config.SnapshotWorkflowRunType),
+			responses: map[string]string{
+				"/repos/icecrime/repo/actions/runs": `{"total_count": 1, "workflow_runs": [{"id": 30433642, "event": "push", "conclusion": "success", "head_branch": "master"}]}`,
+			},
+			expected: []string{"workflow_run/30433642"},
+			check: func(t *testing.T, requests map[string][]url.Values, store *testBlobStore) {
+				if queries := requests["/repos/icecrime/repo/actions/runs"]; len(queries) != 1 || queries[0].Get("created") != ">=2016-01-03T00:00:00Z" {
+					t.Fatalf("unexpected queries for incremental synchronization %v", queries)
+				}
+			},
+		},
+		{
+			// The time of the synchronization is recorded along with the
+			// metrics of the repository.
+			name:     "repository",
+			targets:  []SyncTarget{SyncTargetRepository},
+			eventSet: newTestEventSet(config.SnapshotRepositoryType),
+			responses: map[string]string{
+				"/repos/icecrime/repo": `{"id": 4164482, "stargazers_count": 42, "default_branch": "master"}`,
+			},
+			expected: []string{"repository/4164482"},
+			check: func(t *testing.T, requests map[string][]url.Values, store *testBlobStore) {
+				data := store.blobs[0].Data
+				if stars, _ := data.Get("stargazers_count").Int(); stars != 42 {
+					t.Fatalf("unexpected stargazers count %d", stars)
+				}
+				if syncedAt, err := time.Parse(time.RFC3339, data.Get("synced_at").MustString()); err != nil || syncedAt.IsZero() {
+					t.Fatalf("unexpected synchronization time %q", data.Get("synced_at").MustString())
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var mu sync.Mutex
+		requests := make(map[string][]url.Values)
+		mux := http.NewServeMux()
+		for path, response := range test.responses {
+			path, response, paginated := path, response, test.paginated
+			mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				requests[path] = append(requests[path], req.URL.Query())
+				mu.Unlock()
+				if paginated {
+					w.Header().Set("Link", `<`+req.URL.Path+`?page=2>; rel="next"`)
+				}
+				w.Write([]byte(response))
+			})
+		}
+		cmd, store, srv := newTestSyncCommand(mux)
+
+		cmd.options.Targets = test.targets
+		if test.incremental {
+			checkpoints, _ := state.Open("")
+			checkpoints.Set(checkpointKey(&testRepository), &syncCheckpoint{
+				UpdatedAt: time.Date(2016, 1, 3, 0, 0, 0, 0, time.UTC),
+			})
+			cmd.options.State = GitHubStateFilterAll
+			cmd.options.Checkpoints = checkpoints
+			cmd.options.Incremental = true
+		}
+		repo := testRepository
+		if test.eventSet != nil {
+			repo.EventSet = test.eventSet
+		}
+		cmd.Run([]*storage.Repository{&repo})
+		srv.Close()
+
+		var stored []string
+		for _, b := range store.blobs {
+			stored = append(stored, b.Type+"/"+b.ID)
+		}
+		sort.Strings(stored)
+		if !reflect.DeepEqual(stored, test.expected) {
+			t.Fatalf("%s: unexpected stored items %v, expected %v", test.name, stored, test.expected)
+		}
+		if test.check != nil {
+			test.check(t, requests, store)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package github
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"cmd/vossibility-collector/blob"
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/storage"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/google/go-github/github"
+)
+
+// trafficDateFormat is the format of the dates which identify the traffic
+// documents.
+const trafficDateFormat = "2006-01-02"
+
+// trafficCount is the number of views or clones of a repository for a day.
+type trafficCount struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     int       `json:"count"`
+	Uniques   int       `json:"uniques"`
+}
+
+// trafficCounts is the response of the views and clones traffic API.
+type trafficCounts struct {
+	Views  []trafficCount `json:"views"`
+	Clones []trafficCount `json:"clones"`
+}
+
+// trafficSource is an entry of the referrers or popular paths traffic API,
+// which covers the last two weeks.
+type trafficSource struct {
+	Referrer string `json:"referrer,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Count    int    `json:"count"`
+	Uniques  int    `json:"uniques"`
+}
+
+// CollectTraffic retrieves the traffic statistics of the repositories the
+// token has push access to, and stores them in the traffic index. The views
+// and clones are stored per day, and the referrers and popular paths as of the
+// day of the collection: documents are identified by their date, so that
+// collecting the same day again updates them.
+func CollectTraffic(client *github.Client, blobStore storage.BlobStore, repos []*storage.Repository) {
+	for _, r := range repos {
+		if err := collectRepositoryTraffic(client, blobStore, r, time.Now()); err != nil {
+			log.Errorf("error collecting traffic for %s: %v", r.PrettyName(), err)
+		}
+	}
+}
+
+func collectRepositoryTraffic(client *github.Client, blobStore storage.BlobStore, r *storage.Repository, now time.Time) error {
+	// The traffic API is restricted to users with push access.
+	repo, _, err := client.Repositories.Get(r.User, r.Repo)
+	if err != nil {
+		return err
+	}
+	if repo.Permissions == nil || !(*repo.Permissions)["push"] {
+		log.Infof("no push access to repository %s: not collecting traffic", r.PrettyName())
+		return nil
+	}
+
+	var blobs []*blob.Blob
+	for _, kind := range []string{"views", "clones"} {
+		var counts trafficCounts
+		if err := getTraffic(client, r, kind, &counts); err != nil {
+			return err
+		}
+		typ, list := config.GitHubTypeTrafficViews, counts.Views
+		if kind == "clones" {
+			typ, list = config.GitHubTypeTrafficClones, counts.Clones
+		}
+		for _, c := range list {
+			b := blob.NewBlob(typ, c.Timestamp.UTC().Format(trafficDateFormat))
+			b.Timestamp = c.Timestamp
+			b.Data.Set("repository", r.FullName())
+			b.Data.Set("timestamp", c.Timestamp.UTC().Format(time.RFC3339))
+			b.Data.Set("count", c.Count)
+			b.Data.Set("uniques", c.Uniques)
+			blobs = append(blobs, b)
+		}
+	}
+
+	day := now.UTC().Format(trafficDateFormat)
+	for _, kind := range []string{"popular/referrers", "popular/paths"} {
+		var sources []trafficSource
+		if err := getTraffic(client, r, kind, &sources); err != nil {
+			return err
+		}
+		for _, s := range sources {
+			typ, key := config.GitHubTypeTrafficReferrer, s.Referrer
+			if kind == "popular/paths" {
+				typ, key = config.GitHubTypeTrafficPath, s.Path
+			}
+			b := blob.NewBlob(typ, day+"-"+url.QueryEscape(key))
+			b.Timestamp = now
+			b.Data.Set("repository", r.FullName())
+			b.Data.Set("date", day)
+			if s.Referrer != "" {
+				b.Data.Set("referrer", s.Referrer)
+			} else {
+				b.Data.Set("path", s.Path)
+				b.Data.Set("title", s.Title)
+			}
+			b.Data.Set("count", s.Count)
+			b.Data.Set("uniques", s.Uniques)
+			blobs = append(blobs, b)
+		}
+	}
+
+	for _, b := range blobs {
+		if err := blobStore.Store(storage.StoreTraffic, r, b); err != nil {
+			return err
+		}
+	}
+	log.Infof("collected %d traffic documents for %s", len(blobs), r.PrettyName())
+	return nil
+}
+
+// getTraffic retrieves the specified traffic statistics of the repository.
+func getTraffic(client *github.Client, r *storage.Repository, kind string, v interface{}) error {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/traffic/%s", r.User, r.Repo, kind), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Do(req, v); err != nil {
+		return fmt.Errorf("retrieve traffic %s: %v", kind, err)
+	}
+	return nil
+}
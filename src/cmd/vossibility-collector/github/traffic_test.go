@@ -0,0 +1,73 @@
+package github
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"cmd/vossibility-collector/storage"
+)
+
+func TestCollectTraffic(t *testing.T) {
+	push := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/icecrime/repo", func(w http.ResponseWriter, req *http.Request) {
+		if push {
+			w.Write([]byte(`{"id": 1, "permissions": {"admin": false, "push": true, "pull": true}}`))
+		} else {
+			w.Write([]byte(`{"id": 1, "permissions": {"admin": false, "push": false, "pull": true}}`))
+		}
+	})
+	mux.HandleFunc("/repos/icecrime/repo/traffic/views", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"count": 14, "uniques": 6, "views": [{"timestamp": "2016-01-01T00:00:00Z", "count": 4, "uniques": 2}, {"timestamp": "2016-01-02T00:00:00Z", "count": 10, "uniques": 4}]}`))
+	})
+	mux.HandleFunc("/repos/icecrime/repo/traffic/clones", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"count": 1, "uniques": 1, "clones": [{"timestamp": "2016-01-02T00:00:00Z", "count": 1, "uniques": 1}]}`))
+	})
+	mux.HandleFunc("/repos/icecrime/repo/traffic/popular/referrers", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`[{"referrer": "google.com", "count": 4, "uniques": 3}]`))
+	})
+	mux.HandleFunc("/repos/icecrime/repo/traffic/popular/paths", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`[{"path": "/icecrime/repo", "title": "repo", "count": 3, "uniques": 2}]`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	now := time.Date(2016, 1, 3, 12, 0, 0, 0, time.UTC)
+
+	// Nothing is collected without push access.
+	store := &testBlobStore{}
+	if err := collectRepositoryTraffic(client, store, &testRepository, now); err != nil {
+		t.Fatalf("unexpected error collecting traffic: %v", err)
+	}
+	if len(store.ids) != 0 {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+
+	// Documents are identified by their date so that collecting again
+	// updates them.
+	push = true
+	if err := collectRepositoryTraffic(client, store, &testRepository, now); err != nil {
+		t.Fatalf("unexpected error collecting traffic: %v", err)
+	}
+	sort.Strings(store.ids)
+	expected := []string{"2016-01-01", "2016-01-02", "2016-01-02", "2016-01-03-%2Ficecrime%2Frepo", "2016-01-03-google.com"}
+	if len(store.ids) != len(expected) {
+		t.Fatalf("unexpected stored items %v", store.ids)
+	}
+	for i := range expected {
+		if store.ids[i] != expected[i] {
+			t.Fatalf("unexpected stored items %v", store.ids)
+		}
+	}
+	for i, s := range store.storages {
+		if s != storage.StoreTraffic {
+			t.Fatalf("unexpected storage %v for %q", s, store.blobs[i].ID)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package github
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
+	"time"
 
 	"cmd/vossibility-collector/config"
 	"cmd/vossibility-collector/storage"
@@ -9,10 +12,66 @@ import (
 	"github.com/google/go-github/github"
 )
 
+// PartialMessage is the subset of a live event message which holds the GitHub
+// delivery headers.
+//
+// The GitHub payload is either the message itself (with the headers merged in
+// as top-level attributes), or embedded verbatim as the "payload" attribute.
+// Only the latter allows to verify the delivery signature, as the original
+// bytes of the payload can't be recovered in the former case.
 type PartialMessage struct {
-	GitHubEvent    string `json:"X-GitHub-Event"`
-	GitHubDelivery string `json:"X-GitHub-Delivery"`
-	HubSignature   string `json:"X-Hub-Signature"`
+	GitHubEvent     string          `json:"X-GitHub-Event"`
+	GitHubDelivery  string          `json:"X-GitHub-Delivery"`
+	HubSignature    string          `json:"X-Hub-Signature"`
+	HubSignature256 string          `json:"X-Hub-Signature-256"`
+	Payload         json.RawMessage `json:"payload,omitempty"`
+
+	// ReceivedAt is the optional time at which the event was received, as
+	// recorded by the tool which archived the message.
+	ReceivedAt *time.Time `json:"X-Received-At,omitempty"`
+}
+
+// RawPayload returns the GitHub payload carried by the message body.
+func (p *PartialMessage) RawPayload(body []byte) []byte {
+	if p.IsMerged() {
+		return body
+	}
+	return p.Payload
+}
+
+// IsMerged returns whether the headers are merged into the GitHub payload, in
+// which case its signature can't be verified.
+func (p *PartialMessage) IsMerged() bool {
+	return len(p.Payload) == 0
+}
+
+// Signature returns the strongest signature available for the message.
+func (p *PartialMessage) Signature() string {
+	if p.HubSignature256 != "" {
+		return p.HubSignature256
+	}
+	return p.HubSignature
+}
+
+// partialPayload is the subset of a GitHub event payload which identifies the
+// repository the event relates to.
+type partialPayload struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// RepositoryFullName returns the "user/repo" identifier of the repository a
+// GitHub event payload relates to.
+func RepositoryFullName(payload []byte) (string, error) {
+	var p partialPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", err
+	}
+	if p.Repository.FullName == "" {
+		return "", fmt.Errorf("payload has no repository information")
+	}
+	return p.Repository.FullName, nil
 }
 
 // githubPagedIndexer abstracts functions that list GitHub objects in a paged
@@ -47,6 +106,13 @@ func (g githubIssue) Type() string {
 type githubEnrichedPR struct {
 	*github.PullRequest
 	Labels []github.Label `json:"labels,omitempty"`
+
+	// Reviews, RequestedReviewers, RequestedTeams and CI are only retrieved
+	// when the corresponding enrichment is requested.
+	Reviews            []json.RawMessage `json:"reviews,omitempty"`
+	RequestedReviewers []json.RawMessage `json:"requested_reviewers,omitempty"`
+	RequestedTeams     []json.RawMessage `json:"requested_teams,omitempty"`
+	CI                 *ciStatus         `json:"ci,omitempty"`
 }
 
 func (g *githubEnrichedPR) ID() string {
@@ -57,7 +123,7 @@ func (g *githubEnrichedPR) Type() string {
 	return config.GitHubTypePullRequest
 }
 
-func pullRequestFromIssue(cli *github.Client, repo *storage.Repository, i *github.Issue) (githubIndexedItem, error) {
+func pullRequestFromIssue(cli *github.Client, repo *storage.Repository, i *github.Issue) (*githubEnrichedPR, error) {
 	pr, _, err := cli.PullRequests.Get(repo.User, repo.Repo, *i.Number)
 	if err != nil {
 		return nil, err
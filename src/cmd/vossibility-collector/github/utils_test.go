@@ -0,0 +1,36 @@
+package github
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRepositoryFullName(t *testing.T) {
+	if v, err := RepositoryFullName([]byte(`{"repository":{"full_name":"icecrime/repo"}}`)); err != nil {
+		t.Fatalf("unexpected error retrieving repository name: %v", err)
+	} else if expected := "icecrime/repo"; v != expected {
+		t.Fatalf("got repository name %q, expected %q", v, expected)
+	}
+
+	for _, payload := range []string{`{}`, `{"repository":{}}`, `invalid`} {
+		if v, err := RepositoryFullName([]byte(payload)); err == nil {
+			t.Fatalf("expected error for payload %s, got %q", payload, v)
+		}
+	}
+}
+
+func TestPartialMessageReceivedAt(t *testing.T) {
+	var p PartialMessage
+	if err := json.Unmarshal([]byte(`{"X-GitHub-Event":"issues"}`), &p); err != nil {
+		t.Fatalf("unexpected error unmarshaling message: %v", err)
+	} else if p.ReceivedAt != nil {
+		t.Fatalf("unexpected receive time %v", p.ReceivedAt)
+	}
+
+	if err := json.Unmarshal([]byte(`{"X-Received-At":"2015-10-21T16:29:00Z"}`), &p); err != nil {
+		t.Fatalf("unexpected error unmarshaling message: %v", err)
+	} else if expected := time.Date(2015, 10, 21, 16, 29, 0, 0, time.UTC); p.ReceivedAt == nil || !p.ReceivedAt.Equal(expected) {
+		t.Fatalf("got receive time %v, expected %v", p.ReceivedAt, expected)
+	}
+}
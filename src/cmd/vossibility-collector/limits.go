@@ -21,6 +21,14 @@ Search:
   Reset:     {{ .Search.Reset }}
 `
 
+const CacheOutputFormat = `
+Cache:
+  Hits:      {{ .Hits }}
+  Misses:    {{ .Misses }}
+  Entries:   {{ .Entries }}
+  Size:      {{ .Size }} bytes
+`
+
 var limitsCommand = cli.Command{
 	Name:   "limits",
 	Usage:  "get information about your GitHub API rate limits",
@@ -29,7 +37,7 @@ var limitsCommand = cli.Command{
 
 func doLimitsCommand(c *cli.Context) {
 	config := ParseConfigOrDie(c.GlobalString("config"))
-	client := github.NewClient(config.GitHubAPIToken)
+	client := NewGitHubClient(config)
 
 	rl, _, err := client.RateLimits()
 	if err != nil {
@@ -38,4 +46,15 @@ func doLimitsCommand(c *cli.Context) {
 
 	tmpl, _ := template.New("").Parse(OutputFormat)
 	tmpl.Execute(os.Stdout, rl)
+
+	// Show the statistics of the responses cache, if any.
+	if config.Cache.Path == "" {
+		return
+	}
+	stats, err := github.ReadCacheStats(config.Cache.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tmpl, _ = template.New("").Parse(CacheOutputFormat)
+	tmpl.Execute(os.Stdout, stats)
 }
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"cmd/vossibility-collector/blob"
+	"cmd/vossibility-collector/deadletter"
+	"cmd/vossibility-collector/dedup"
 	"cmd/vossibility-collector/github"
 	"cmd/vossibility-collector/storage"
 
@@ -19,21 +22,114 @@ import (
 const (
 	// LabelsAttribute is the key in a GitHub payload for the labels.
 	LabelsAttribute = "pull_request.labels"
+
+	// DefaultMaxAttempts is the default number of processing attempts after
+	// which a failing event is sent to the dead letter spool.
+	DefaultMaxAttempts = 5
+
+	// DefaultSeenSetSize is the default number of delivery identifiers to
+	// remember for deduplication.
+	DefaultSeenSetSize = 10000
+
+	// DefaultSeenSetTTL is the default duration for which a delivery
+	// identifier is remembered for deduplication.
+	DefaultSeenSetTTL = 24 * time.Hour
 )
 
-func NewMessageHandler(client *gh.Client, repo *storage.Repository, pauseLock *sync.RWMutex) *MessageHandler {
+// liveOptions are the options for live events processing shared by all the
+// MessageHandler instances.
+type liveOptions struct {
+	// DeadLetter is the spool for events which failed to be processed, or nil
+	// if failed events should simply be dropped.
+	DeadLetter *deadletter.Spool
+
+	// MaxAttempts is the number of processing attempts after which a failing
+	// event is sent to the dead letter spool.
+	MaxAttempts int
+
+	// Seen is the set of already processed deliveries, or nil if duplicated
+	// deliveries should be processed again.
+	Seen *dedup.SeenSet
+}
+
+// newLiveOptions creates the liveOptions from the configuration.
+func newLiveOptions(c *Config) (*liveOptions, error) {
+	opts := &liveOptions{
+		MaxAttempts: c.DeadLetter.MaxAttempts,
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.DeadLetter.Path != "" {
+		spool, err := deadletter.NewSpool(c.DeadLetter.Path)
+		if err != nil {
+			return nil, err
+		}
+		opts.DeadLetter = spool
+	}
+
+	size, ttl := c.Deduplication.Size, DefaultSeenSetTTL
+	if size <= 0 {
+		size = DefaultSeenSetSize
+	}
+	if c.Deduplication.TTL != "" {
+		d, err := time.ParseDuration(c.Deduplication.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deduplication ttl %q: %v", c.Deduplication.TTL, err)
+		}
+		ttl = d
+	}
+	if c.Deduplication.Path == "" {
+		opts.Seen = dedup.New(size, ttl)
+	} else {
+		seen, err := dedup.Open(c.Deduplication.Path, size, ttl)
+		if err != nil {
+			return nil, err
+		}
+		opts.Seen = seen
+	}
+	return opts, nil
+}
+
+// newLiveOptionsOrDie creates the liveOptions from the configuration and exits
+// in case of error.
+func newLiveOptionsOrDie(c *Config) *liveOptions {
+	opts, err := newLiveOptions(c)
+	if err != nil {
+		log.Fatalf("failed to initialize live events processing: %v", err)
+	}
+	return opts
+}
+
+func NewMessageHandler(client *gh.Client, repo *storage.Repository, pauseLock *sync.RWMutex, opts *liveOptions) *MessageHandler {
 	return &MessageHandler{
 		client:    client,
 		repo:      repo,
 		store:     storage.NewTransformingBlobStore(),
+		options:   opts,
 		pauseLock: pauseLock,
 	}
 }
 
 type MessageHandler struct {
-	client *gh.Client
-	repo   *storage.Repository
-	store  storage.BlobStore
+	client  *gh.Client
+	store   storage.BlobStore
+	options *liveOptions
+
+	// The repository is swapped when the configuration is reloaded. This
+	// happens while holding the pause lock for writing, but signatures are
+	// verified outside of the pause lock by the webhook receiver.
+	repoLock sync.RWMutex
+	repo     *storage.Repository
+
+	// catchAll is set for the handler of payloads which relate to none of
+	// the configured repositories. Those are stored in the catch-all
+	// repository, but its secret and its GitHub name don't apply to them:
+	// they are verified against catchAllSecret instead, and rejected when it
+	// is empty but requireSecret is set. Both are protected by repoLock.
+	catchAll       bool
+	catchAllSecret string
+	requireSecret  bool
 
 	// The RWMutex allows us to implement pausing: all MessageHandler share the
 	// same instance and take a read lock when they start handling a message.
@@ -47,6 +143,20 @@ type MessageHandler struct {
 	pauseLock *sync.RWMutex
 }
 
+// Repository returns the repository the handler processes events for.
+func (m *MessageHandler) Repository() *storage.Repository {
+	m.repoLock.RLock()
+	defer m.repoLock.RUnlock()
+	return m.repo
+}
+
+// SetRepository replaces the repository the handler processes events for.
+func (m *MessageHandler) SetRepository(repo *storage.Repository) {
+	m.repoLock.Lock()
+	defer m.repoLock.Unlock()
+	m.repo = repo
+}
+
 func (m *MessageHandler) HandleMessage(n *nsq.Message) error {
 	m.pauseLock.RLock()
 	defer m.pauseLock.RUnlock()
@@ -56,20 +166,162 @@ func (m *MessageHandler) HandleMessage(n *nsq.Message) error {
 		log.Error(err)
 		return nil // No need to retry
 	}
-	return m.handleEvent(n.Timestamp, p.GitHubEvent, p.GitHubDelivery, n.Body)
+
+	payload := p.RawPayload(n.Body)
+	if err := m.verifyMessage(&p, payload); err != nil {
+		return nil // No need to retry
+	}
+
+	err := m.process(n.Timestamp, p.GitHubEvent, p.GitHubDelivery, payload)
+	if err != nil && int(n.Attempts) >= m.options.MaxAttempts {
+		if m.options.DeadLetter == nil {
+			log.Errorf("giving up delivery %q after %d attempt(s): %v", p.GitHubDelivery, n.Attempts, err)
+			return nil
+		}
+		// Returning nil when the message was successfully sent to the dead
+		// letter spool prevents NSQ from requeuing it.
+		if m.SendToDeadLetter(n.Timestamp, p.GitHubEvent, p.GitHubDelivery, payload, int(n.Attempts), err) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// SendToDeadLetter stores an event which failed to be processed into the dead
+// letter spool. It returns an error if no spool is configured, or if the
+// event couldn't be stored.
+func (m *MessageHandler) SendToDeadLetter(timestamp int64, event, delivery string, payload []byte, attempts int, cause error) error {
+	if m.options.DeadLetter == nil {
+		return fmt.Errorf("no dead letter spool configured")
+	}
+	repo := m.Repository()
+	r := &deadletter.Record{
+		Repository: repo.GivenName,
+		Event:      event,
+		Delivery:   delivery,
+		Error:      cause.Error(),
+		Attempts:   attempts,
+		Timestamp:  time.Unix(0, timestamp),
+		Payload:    payload,
+	}
+	if err := m.options.DeadLetter.Write(r); err != nil {
+		log.Errorf("failed to write delivery %q to the dead letter spool: %v", delivery, err)
+		return err
+	}
+	count := incrStat(repo.GivenName, StatDeadLettered)
+	log.Warnf("delivery %q for repository %s sent to the dead letter spool as %q after %d attempt(s) (%d sent so far)", delivery, repo.PrettyName(), r.ID, attempts, count)
+	return nil
+}
+
+// setCatchAllSecret sets the secret the payloads of the catch-all handler are
+// verified against, and whether they are rejected when there is none.
+func (m *MessageHandler) setCatchAllSecret(secret string, required bool) {
+	m.repoLock.Lock()
+	defer m.repoLock.Unlock()
+	m.catchAllSecret, m.requireSecret = secret, required
+}
+
+// VerifySignature checks the signature of a payload against the secret of the
+// repository, if any. Failures are logged and counted. Payloads of the
+// catch-all handler relate to other repositories, and are verified against the
+// catch-all secret instead.
+func (m *MessageHandler) VerifySignature(signature string, payload []byte) error {
+	repo, secret, required := m.verification()
+	var err error
+	switch {
+	case secret != "":
+		err = github.VerifySignature(secret, signature, payload)
+	case required:
+		err = fmt.Errorf("no catch-all secret to verify the payload of another repository")
+	}
+	if err != nil {
+		m.rejectSignature(repo, err)
+	}
+	return err
 }
 
-func (m *MessageHandler) handleEvent(timestamp int64, event, delivery string, payload json.RawMessage) error {
+// verifyMessage checks the signature of the payload of an NSQ message. The
+// signature applies to the bytes GitHub sent, which can't be recovered when
+// the headers are merged into the payload: such messages are rejected with an
+// explicit error rather than a signature mismatch when verification applies.
+func (m *MessageHandler) verifyMessage(p *github.PartialMessage, payload []byte) error {
+	if repo, secret, required := m.verification(); p.IsMerged() && (secret != "" || required) {
+		err := fmt.Errorf("can't verify the signature of a payload merged with its headers: embed the payload verbatim as the \"payload\" attribute")
+		m.rejectSignature(repo, err)
+		return err
+	}
+	return m.VerifySignature(p.Signature(), payload)
+}
+
+// verification returns the repository of the handler, the secret its payloads
+// are verified against, and whether a secret is required.
+func (m *MessageHandler) verification() (*storage.Repository, string, bool) {
+	m.repoLock.RLock()
+	defer m.repoLock.RUnlock()
+	if m.catchAll {
+		return m.repo, m.catchAllSecret, m.requireSecret
+	}
+	return m.repo, m.repo.Secret, false
+}
+
+func (m *MessageHandler) rejectSignature(repo *storage.Repository, err error) {
+	count := incrStat(repo.GivenName, StatRejectedSignature)
+	log.Errorf("rejecting event for repository %s: %v (%d rejected so far)", repo.PrettyName(), err, count)
+}
+
+// HandleDelivery processes a GitHub delivery received outside of NSQ (for
+// example through the webhook receiver). Like HandleMessage, it honors the
+// pause lock.
+func (m *MessageHandler) HandleDelivery(timestamp int64, event, delivery string, payload []byte) error {
+	m.pauseLock.RLock()
+	defer m.pauseLock.RUnlock()
+	return m.process(timestamp, event, delivery, payload)
+}
+
+// process handles the event unless its delivery was already processed.
+func (m *MessageHandler) process(timestamp int64, event, delivery string, payload []byte) error {
+	// Legacy messages may not carry a delivery identifier, in which case we
+	// have no way to tell duplicates apart.
+	repo := m.Repository()
+	if m.options.Seen == nil || delivery == "" {
+		return m.handleEvent(repo, timestamp, event, delivery, payload)
+	}
+
+	// Reserve the delivery while processing it, so that a concurrent
+	// redelivery of the same event is considered a duplicate.
+	if !m.options.Seen.TryAdd(delivery) {
+		count := incrStat(repo.GivenName, StatDuplicates)
+		log.Infof("ignoring duplicated delivery %q for repository %s (%d duplicates so far)", delivery, repo.PrettyName(), count)
+		return nil
+	}
+
+	// Only remember deliveries which were successfully processed, as failed
+	// ones are expected to be delivered again.
+	if err := m.handleEvent(repo, timestamp, event, delivery, payload); err != nil {
+		m.options.Seen.Release(delivery)
+		return err
+	}
+	if err := m.options.Seen.Add(delivery); err != nil {
+		log.Errorf("failed to remember delivery %q: %v", delivery, err)
+	}
+	return nil
+}
+
+func (m *MessageHandler) handleEvent(repo *storage.Repository, timestamp int64, event, delivery string, payload json.RawMessage) error {
 	// Check if we are subscribed to this particular event type.
-	if !m.repo.IsSubscribed(event) {
-		log.Debugf("ignoring event %q for repository %s", event, m.repo.PrettyName())
+	if !repo.IsSubscribed(event) {
+		log.Debugf("ignoring event %q for repository %s", event, repo.PrettyName())
 		return nil
 	}
-	log.Infof("receive event %q for repository %q", event, m.repo.PrettyName())
+	log.Infof("receive event %q for repository %q", event, repo.PrettyName())
 
 	// Create the blob object and complete any data that needs to be.
 	b, err := blob.NewBlobFromPayload(event, delivery, payload)
-	if err = m.prepareForStorage(b); err != nil {
+	if err != nil {
+		log.Errorf("creating blob for event %q: %v", event, err)
+		return nil // No need to retry
+	}
+	if err = m.prepareForStorage(repo, b); err != nil {
 		log.Errorf("preparing event %q for storage: %v", event, err)
 		return err
 	}
@@ -77,16 +329,28 @@ func (m *MessageHandler) handleEvent(timestamp int64, event, delivery string, pa
 	// Take the timestamp from the NSQ Message (useful if the queue was put on
 	// hold or if the process is catching up). This timestamp is a UnixNano.
 	b.Timestamp = time.Unix(0, timestamp)
-	return m.store.Store(storage.StoreLiveEvent, m.repo, b)
+	return m.store.Store(storage.StoreLiveEvent, repo, b)
 }
 
-func (m *MessageHandler) prepareForStorage(o *blob.Blob) error {
+func (m *MessageHandler) prepareForStorage(repo *storage.Repository, o *blob.Blob) error {
 	if o.Type != github.EvtPullRequest || o.HasAttribute(LabelsAttribute) {
 		return nil
 	}
+	// The payloads of the catch-all handler relate to another repository
+	// than the one they are stored in.
+	user, name := repo.User, repo.Repo
+	if m.catchAll {
+		fullName := o.Data.GetPath("repository", "full_name").MustString()
+		parts := strings.SplitN(fullName, "/", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid repository name %q in payload", fullName)
+		}
+		user, name = parts[0], parts[1]
+	}
+
 	number := o.Data.Get("number").MustInt()
-	log.Debugf("fetching labels for %s #%d", m.repo.PrettyName(), number)
-	l, _, err := m.client.Issues.ListLabelsByIssue(m.repo.User, m.repo.Repo, number, &gh.ListOptions{})
+	log.Debugf("fetching labels for %s/%s #%d", user, name, number)
+	l, _, err := m.client.Issues.ListLabelsByIssue(user, name, number, &gh.ListOptions{})
 	if err != nil {
 		return fmt.Errorf("retrieve labels for issue %d: %v", number, err)
 	}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"cmd/vossibility-collector/blob"
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/deadletter"
+	"cmd/vossibility-collector/github"
+	"cmd/vossibility-collector/storage"
+
+	"github.com/bitly/go-nsq"
+)
+
+type failingBlobStore struct{}
+
+func (failingBlobStore) Store(storage.Storage, *storage.Repository, *blob.Blob) error {
+	return errors.New("store failure")
+}
+
+func TestHandleMessageDeadLetterAfterMaxAttempts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := deadletter.NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const maxAttempts = 8
+	handler := &MessageHandler{
+		repo: &storage.Repository{
+			GivenName: "testrepo",
+			EventSet:  storage.EventSet{"push": nil},
+		},
+		store:     failingBlobStore{},
+		options:   &liveOptions{DeadLetter: spool, MaxAttempts: maxAttempts},
+		pauseLock: &sync.RWMutex{},
+	}
+	body := []byte(`{"X-GitHub-Event": "push", "X-GitHub-Delivery": "1", "payload": {"ref": "master"}}`)
+
+	// The consumer keeps delivering the message past the default of 5 NSQ
+	// attempts, until the handler sends it to the spool.
+	cfg := newConsumerConfig()
+	for attempts := 1; attempts <= maxAttempts; attempts++ {
+		if cfg.MaxAttempts > 0 && attempts > int(cfg.MaxAttempts) {
+			t.Fatalf("consumer gives up the message after %d attempts", cfg.MaxAttempts)
+		}
+		msg := nsq.NewMessage(nsq.MessageID{}, body)
+		msg.Attempts = uint16(attempts)
+		err := handler.HandleMessage(msg)
+		if attempts < maxAttempts && err == nil {
+			t.Fatalf("message not requeued after %d attempts", attempts)
+		} else if attempts == maxAttempts && err != nil {
+			t.Fatalf("message requeued after %d attempts: %v", attempts, err)
+		}
+	}
+
+	records, err := spool.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Attempts != maxAttempts {
+		t.Fatalf("unexpected dead letter records %v", records)
+	}
+}
+
+func TestCatchAllHandler(t *testing.T) {
+	var requested string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requested = req.URL.Path
+		w.Write([]byte(`[{"name": "bug"}]`))
+	}))
+	defer srv.Close()
+	client := github.NewClient("")
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	catchAll := &storage.Repository{
+		RepositoryConfig: config.RepositoryConfig{User: "icecrime", Repo: "catchall", Secret: "secret"},
+		GivenName:        "catchall",
+	}
+	router := newRepositoryRouter(client, nil, nil, &sync.RWMutex{}, &liveOptions{})
+	router.SetCatchAll(catchAll, "")
+	payload := []byte(`{"number": 12, "repository": {"full_name": "docker/docker"}}`)
+	handler, err := router.Route(payload)
+	if err != nil {
+		t.Fatalf("unexpected routing error: %v", err)
+	}
+
+	// The secret of the catch-all repository doesn't apply to payloads of
+	// other repositories.
+	if err := handler.VerifySignature("", payload); err != nil {
+		t.Fatalf("unexpected signature error: %v", err)
+	}
+
+	// Labels are retrieved from the repository of the payload.
+	b, err := blob.NewBlobFromPayload(github.EvtPullRequest, "1", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.prepareForStorage(catchAll, b); err != nil {
+		t.Fatalf("unexpected error preparing for storage: %v", err)
+	}
+	if requested != "/repos/docker/docker/issues/12/labels" {
+		t.Fatalf("labels retrieved from %q", requested)
+	}
+}
+
+func TestCatchAllSignature(t *testing.T) {
+	repo := &storage.Repository{
+		RepositoryConfig: config.RepositoryConfig{User: "icecrime", Repo: "repo", Secret: "secret"},
+		GivenName:        "repo",
+	}
+	catchAll := &storage.Repository{
+		RepositoryConfig: config.RepositoryConfig{User: "icecrime", Repo: "catchall"},
+		GivenName:        "catchall",
+	}
+	router := newRepositoryRouter(nil, []*storage.Repository{repo}, nil, &sync.RWMutex{}, &liveOptions{})
+	payload := []byte(`{"repository": {"full_name": "docker/docker"}}`)
+	forged := "sha1=" + hex.EncodeToString(hmacSHA1("forged", payload))
+
+	// Without a catch-all secret, a payload naming an unknown repository
+	// can't go around the verification of the configured repositories.
+	router.SetCatchAll(catchAll, "")
+	handler, err := router.Route(payload)
+	if err != nil {
+		t.Fatalf("unexpected routing error: %v", err)
+	}
+	if err := handler.VerifySignature(forged, payload); err == nil {
+		t.Fatal("forged catch-all payload accepted without a catch-all secret")
+	}
+
+	// With a catch-all secret, only the payloads it signed are accepted.
+	router.SetCatchAll(catchAll, "catchall-secret")
+	if err := handler.VerifySignature(forged, payload); err == nil {
+		t.Fatal("forged catch-all payload accepted")
+	}
+	signature := "sha1=" + hex.EncodeToString(hmacSHA1("catchall-secret", payload))
+	if err := handler.VerifySignature(signature, payload); err != nil {
+		t.Fatalf("unexpected signature error: %v", err)
+	}
+}
+
+func TestHandleMessageSignature(t *testing.T) {
+	handler := &MessageHandler{
+		repo: &storage.Repository{
+			RepositoryConfig: config.RepositoryConfig{User: "icecrime", Repo: "repo", Secret: "secret"},
+			GivenName:        "repo",
+			EventSet:         storage.EventSet{"push": nil},
+		},
+		store:     failingBlobStore{},
+		options:   &liveOptions{MaxAttempts: 5},
+		pauseLock: &sync.RWMutex{},
+	}
+
+	// GitHub signs the body it sends as is, whitespace included.
+	payload := "{\n  \"ref\": \"refs/heads/master\",\n  \"repository\": {\n    \"full_name\": \"icecrime/repo\"\n  }\n}"
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(payload))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	headers := `"X-GitHub-Event": "push", "X-GitHub-Delivery": "1", "X-Hub-Signature-256": "` + signature + `"`
+
+	// Processed messages fail to be stored, while rejected ones are dropped.
+	for _, tc := range []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"raw payload", `{` + headers + `, "payload": ` + payload + `}`, ""},
+		{"merged payload", `{` + headers + `, "ref": "refs/heads/master", "repository": {"full_name": "icecrime/repo"}}`, "merged"},
+		{"missing signature", `{"X-GitHub-Event": "push", "X-GitHub-Delivery": "1", "payload": ` + payload + `}`, "missing signature"},
+	} {
+		var p github.PartialMessage
+		if err := json.Unmarshal([]byte(tc.body), &p); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		err := handler.verifyMessage(&p, p.RawPayload([]byte(tc.body)))
+		if tc.expected == "" && err != nil {
+			t.Fatalf("%s: unexpected signature error: %v", tc.name, err)
+		} else if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Fatalf("%s: got error %v, expected %q", tc.name, err, tc.expected)
+		}
+
+		msg := nsq.NewMessage(nsq.MessageID{}, []byte(tc.body))
+		msg.Attempts = 1
+		if err := handler.HandleMessage(msg); (err != nil) != (tc.expected == "") {
+			t.Fatalf("%s: unexpected processing error %v", tc.name, err)
+		}
+	}
+}
+
+func hmacSHA1(secret string, payload []byte) []byte {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"os"
 
+	"cmd/vossibility-collector/github"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/mattbaird/elastigo/core"
@@ -32,8 +34,11 @@ func main() {
 
 	app.Action = runCommand.Action
 	app.Commands = []cli.Command{
+		deadLetterCommand,
 		limitsCommand,
+		replayCommand,
 		runCommand,
+		serveCommand,
 		syncCommand,
 		syncMappingCommand,
 		syncUsersCommand,
@@ -47,6 +52,13 @@ func main() {
 		return nil
 	}
 
+	// The statistics of the GitHub API responses cache are only saved from
+	// time to time.
+	app.After = func(c *cli.Context) error {
+		github.FlushCacheStats()
+		return nil
+	}
+
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
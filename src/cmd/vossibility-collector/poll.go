@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"cmd/vossibility-collector/github"
+	"cmd/vossibility-collector/state"
+	"cmd/vossibility-collector/storage"
+
+	log "github.com/Sirupsen/logrus"
+	gh "github.com/google/go-github/github"
+)
+
+// pollerCursor is the persisted state of a repositoryPoller.
+type pollerCursor struct {
+	Cursor int64  `json:"cursor"`
+	ETag   string `json:"etag"`
+}
+
+// repositoryPoller is a liveSource which polls the GitHub Events API for a
+// given repository, and feeds the events to its MessageHandler.
+type repositoryPoller struct {
+	handler *MessageHandler
+	poller  *github.EventPoller
+	state   *state.File
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newRepositoryPoller starts polling the GitHub Events API for the repository.
+func newRepositoryPoller(client *gh.Client, repo *storage.Repository, lock *sync.RWMutex, opts *liveOptions, st *state.File) *repositoryPoller {
+	if !st.IsPersistent() {
+		log.Warnf("no state file configured: polling cursor for %s won't survive restarts", repo.PrettyName())
+	}
+	p := &repositoryPoller{
+		handler: NewMessageHandler(client, repo, lock, opts),
+		poller:  github.NewEventPoller(client, repo.User, repo.Repo),
+		state:   st,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	p.loadCursor()
+	go p.run()
+	return p
+}
+
+// Stop requests the poller to exit.
+func (p *repositoryPoller) Stop() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+}
+
+// Wait blocks until the poller has exited.
+func (p *repositoryPoller) Wait() {
+	<-p.done
+}
+
+func (p *repositoryPoller) stateKey() string {
+	return "poll." + p.handler.Repository().GivenName
+}
+
+func (p *repositoryPoller) loadCursor() {
+	var c pollerCursor
+	if ok, err := p.state.Get(p.stateKey(), &c); err != nil {
+		log.Errorf("failed to load polling cursor for %s: %v", p.handler.Repository().PrettyName(), err)
+	} else if ok {
+		p.poller.Cursor, p.poller.ETag = c.Cursor, c.ETag
+	}
+}
+
+func (p *repositoryPoller) saveCursor() {
+	c := pollerCursor{Cursor: p.poller.Cursor, ETag: p.poller.ETag}
+	if err := p.state.Set(p.stateKey(), &c); err != nil {
+		log.Errorf("failed to save polling cursor for %s: %v", p.handler.Repository().PrettyName(), err)
+	}
+}
+
+func (p *repositoryPoller) run() {
+	defer close(p.done)
+
+	var delay time.Duration
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(delay):
+		}
+
+		prev := pollerCursor{Cursor: p.poller.Cursor, ETag: p.poller.ETag}
+		events, interval, err := p.poller.Poll()
+		if err != nil {
+			log.Errorf("polling events for %s: %v", p.handler.Repository().PrettyName(), err)
+		}
+		p.process(events)
+		if (pollerCursor{Cursor: p.poller.Cursor, ETag: p.poller.ETag}) != prev {
+			p.saveCursor()
+		}
+		delay = interval
+	}
+}
+
+// process sends the events through the live pipeline in chronological order,
+// and moves the cursor accordingly. Processing stops at the first event which
+// fails and couldn't be sent to the dead letter spool, so that it is polled
+// again next time.
+func (p *repositoryPoller) process(events []*github.RepositoryEvent) {
+	for _, e := range events {
+		payload, err := e.WebhookPayload()
+		if err != nil {
+			log.Errorf("converting event %s for %s: %v", e.ID, p.handler.Repository().PrettyName(), err)
+		} else if err := p.handler.HandleDelivery(e.CreatedAt.UnixNano(), e.EventType(), e.DeliveryID(), payload); err != nil {
+			log.Errorf("handling event %s for %s: %v", e.ID, p.handler.Repository().PrettyName(), err)
+			if p.handler.SendToDeadLetter(e.CreatedAt.UnixNano(), e.EventType(), e.DeliveryID(), payload, 1, err) != nil {
+				break
+			}
+		}
+		p.poller.Advance(e)
+	}
+}
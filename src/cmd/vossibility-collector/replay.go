@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"cmd/vossibility-collector/github"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+var replayCommand = cli.Command{
+	Name:  "replay",
+	Usage: "replay recorded live events from newline-delimited JSON files",
+	Description: `Each line is a NSQ message body holding the X-GitHub-* headers, and
+   either the GitHub payload merged in or embedded as a "payload" attribute.
+   The optional X-Received-At attribute (RFC3339) gives the event timestamp.
+   Files default to stdin when left unspecified.`,
+	Action: doReplayCommand,
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "repo", Usage: "only replay events for that repository given name"},
+		cli.StringFlag{Name: "event", Usage: "only replay events of that type"},
+		cli.StringFlag{Name: "timestamp", Usage: "RFC3339 timestamp to use instead of the recorded one"},
+		cli.BoolFlag{Name: "dry-run", Usage: "only print the events that would be replayed"},
+	},
+}
+
+// replayFilter is the set of options for a replay job.
+type replayFilter struct {
+	Repo      string
+	Event     string
+	Timestamp time.Time
+	DryRun    bool
+}
+
+// replayStats counts the outcome of a replay job.
+type replayStats struct {
+	Replayed int
+	Skipped  int
+	Failed   int
+}
+
+// doReplayCommand reads NSQ message bodies from the files given as arguments
+// (or from stdin), and sends each of them through the live events pipeline.
+func doReplayCommand(c *cli.Context) {
+	config := ParseConfigOrDie(c.GlobalString("config"))
+	client := NewGitHubClient(config)
+	discoverRepositoriesOrDie(client, config)
+
+	filter := replayFilter{
+		Repo:   c.String("repo"),
+		Event:  c.String("event"),
+		DryRun: c.Bool("dry-run"),
+	}
+	if v := c.String("timestamp"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			log.Fatalf("invalid timestamp %q: %v", v, err)
+		}
+		filter.Timestamp = t
+	}
+
+	// Replayed events bypass the dead letter spool: failures are reported
+	// and the command can simply be run again.
+	lock := sync.RWMutex{}
+	router := newRepositoryRouter(client, config.RepositoryList(), nil, &lock, &liveOptions{})
+
+	files := c.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	stats := replayStats{}
+	for _, filename := range files {
+		if err := replayFile(router, filename, &filter, &stats); err != nil {
+			log.Fatalf("replaying %q: %v", filename, err)
+		}
+	}
+	log.Warnf("replay done: %d replayed, %d skipped, %d failed", stats.Replayed, stats.Skipped, stats.Failed)
+}
+
+func replayFile(router *repositoryRouter, filename string, filter *replayFilter, stats *replayStats) error {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// Lines hold complete GitHub payloads, which can be much larger than the
+	// default scanner buffer.
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxWebhookPayloadSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := replayMessage(router, scanner.Bytes(), filter); err == errReplaySkipped {
+			stats.Skipped++
+		} else if err != nil {
+			log.Errorf("%s:%d: %v", filename, line, err)
+			stats.Failed++
+		} else {
+			stats.Replayed++
+		}
+	}
+	return scanner.Err()
+}
+
+// errReplaySkipped is returned by replayMessage for messages which are
+// excluded by the replay filter.
+var errReplaySkipped = errors.New("message skipped")
+
+func replayMessage(router *repositoryRouter, body []byte, filter *replayFilter) error {
+	var p github.PartialMessage
+	if err := json.Unmarshal(body, &p); err != nil {
+		return err
+	}
+	if filter.Event != "" && p.GitHubEvent != filter.Event {
+		return errReplaySkipped
+	}
+
+	payload := p.RawPayload(body)
+	handler, err := router.Route(payload)
+	if err != nil {
+		return err
+	}
+	if filter.Repo != "" && handler.repo.GivenName != filter.Repo {
+		return errReplaySkipped
+	}
+
+	// The timestamp from the command line takes precedence over the recorded
+	// one, and we default to the current time when neither is available.
+	timestamp := time.Now()
+	if !filter.Timestamp.IsZero() {
+		timestamp = filter.Timestamp
+	} else if p.ReceivedAt != nil {
+		timestamp = *p.ReceivedAt
+	}
+
+	// Events the repository isn't subscribed to would be ignored: count them
+	// as skipped in both modes.
+	if !handler.repo.IsSubscribed(p.GitHubEvent) {
+		return errReplaySkipped
+	}
+	if filter.DryRun {
+		log.Infof("would replay event %q (delivery %q) for repository %s at %s", p.GitHubEvent, p.GitHubDelivery, handler.repo.PrettyName(), timestamp.Format(time.RFC3339))
+		return nil
+	}
+	return handler.HandleDelivery(timestamp.UnixNano(), p.GitHubEvent, p.GitHubDelivery, payload)
+}
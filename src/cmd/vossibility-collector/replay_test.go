@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cmd/vossibility-collector/blob"
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/storage"
+)
+
+// recordingBlobStore records the live events stored for each repository.
+type recordingBlobStore struct {
+	events     []string
+	timestamps []time.Time
+}
+
+func (r *recordingBlobStore) Store(_ storage.Storage, repo *storage.Repository, b *blob.Blob) error {
+	r.events = append(r.events, repo.GivenName+"/"+b.Type)
+	r.timestamps = append(r.timestamps, b.Timestamp)
+	return nil
+}
+
+const testReplayMessages = `{"X-GitHub-Event": "push", "X-GitHub-Delivery": "1", "X-Received-At": "2016-01-01T00:00:00Z", "payload": {"repository": {"full_name": "icecrime/repo1"}}}
+{"X-GitHub-Event": "issues", "X-GitHub-Delivery": "2", "X-Received-At": "2016-01-02T00:00:00Z", "repository": {"full_name": "icecrime/repo1"}}
+
+{"X-GitHub-Event": "push", "X-GitHub-Delivery": "3", "X-Received-At": "2016-01-03T00:00:00Z", "payload": {"repository": {"full_name": "icecrime/repo2"}}}
+{"X-GitHub-Event": "issues", "X-GitHub-Delivery": "4", "payload": {"repository": {"full_name": "icecrime/repo2"}}}
+not a message
+`
+
+func TestReplayFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "events.json")
+	if err := ioutil.WriteFile(filename, []byte(testReplayMessages), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second repository isn't subscribed to issues events.
+	repos := []*storage.Repository{
+		{
+			RepositoryConfig: config.RepositoryConfig{User: "icecrime", Repo: "repo1"},
+			GivenName:        "repo1",
+			EventSet:         storage.EventSet{"push": nil, "issues": nil},
+		},
+		{
+			RepositoryConfig: config.RepositoryConfig{User: "icecrime", Repo: "repo2"},
+			GivenName:        "repo2",
+			EventSet:         storage.EventSet{"push": nil},
+		},
+	}
+	timestamp := time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name       string
+		filter     replayFilter
+		stats      replayStats
+		events     []string
+		timestamps []time.Time
+	}{
+		{
+			name:   "all",
+			stats:  replayStats{Replayed: 3, Skipped: 1, Failed: 1},
+			events: []string{"repo1/push", "repo1/issues", "repo2/push"},
+			timestamps: []time.Time{
+				time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC),
+				time.Date(2016, 1, 3, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:   "repository",
+			filter: replayFilter{Repo: "repo1"},
+			stats:  replayStats{Replayed: 2, Skipped: 2, Failed: 1},
+			events: []string{"repo1/push", "repo1/issues"},
+		},
+		{
+			name:   "event",
+			filter: replayFilter{Event: "push"},
+			stats:  replayStats{Replayed: 2, Skipped: 2, Failed: 1},
+			events: []string{"repo1/push", "repo2/push"},
+		},
+		{
+			name:       "timestamp",
+			filter:     replayFilter{Repo: "repo2", Timestamp: timestamp},
+			stats:      replayStats{Replayed: 1, Skipped: 3, Failed: 1},
+			events:     []string{"repo2/push"},
+			timestamps: []time.Time{timestamp},
+		},
+		{
+			name:   "dry run",
+			filter: replayFilter{DryRun: true},
+			stats:  replayStats{Replayed: 3, Skipped: 1, Failed: 1},
+		},
+	} {
+		router := newRepositoryRouter(nil, repos, nil, &sync.RWMutex{}, &liveOptions{})
+		store := &recordingBlobStore{}
+		for _, h := range router.handlers {
+			h.store = store
+		}
+
+		stats := replayStats{}
+		if err := replayFile(router, filename, &tc.filter, &stats); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if stats != tc.stats {
+			t.Fatalf("%s: got stats %+v, expected %+v", tc.name, stats, tc.stats)
+		}
+		if !reflect.DeepEqual(store.events, tc.events) {
+			t.Fatalf("%s: got stored events %v, expected %v", tc.name, store.events, tc.events)
+		}
+		for i, ts := range tc.timestamps {
+			if !store.timestamps[i].Equal(ts) {
+				t.Fatalf("%s: event %s stored at %v, expected %v", tc.name, store.events[i], store.timestamps[i], ts)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cmd/vossibility-collector/github"
+	"cmd/vossibility-collector/storage"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bitly/go-nsq"
+	gh "github.com/google/go-github/github"
+)
+
+// repositoryRouter dispatches GitHub payloads to the MessageHandler of the
+// repository they relate to, using the "repository.full_name" attribute of the
+// payload. It allows a single source of events (such as a webhook endpoint) to
+// serve multiple repositories.
+type repositoryRouter struct {
+	client    *gh.Client
+	pauseLock *sync.RWMutex
+	options   *liveOptions
+
+	// accept is the optional filter of repositories served by the router.
+	accept func(*storage.Repository) bool
+
+	// The set of repositories may change while events are being routed.
+	mu       sync.RWMutex
+	handlers map[string]*MessageHandler
+
+	// catchAll is the optional MessageHandler for payloads which relate to
+	// none of the repositories.
+	catchAll *MessageHandler
+}
+
+// newRepositoryRouter creates a repositoryRouter with one MessageHandler for
+// each of the specified repositories which are accepted by the optional
+// filter.
+func newRepositoryRouter(client *gh.Client, repos []*storage.Repository, accept func(*storage.Repository) bool, pauseLock *sync.RWMutex, opts *liveOptions) *repositoryRouter {
+	r := &repositoryRouter{
+		client:    client,
+		pauseLock: pauseLock,
+		options:   opts,
+		accept:    accept,
+	}
+	r.Update(repos)
+	return r
+}
+
+// Update replaces the set of repositories served by the router. Handlers are
+// preserved for the repositories which are still served, and are given the
+// new Repository instance.
+func (r *repositoryRouter) Update(repos []*storage.Repository) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	handlers := make(map[string]*MessageHandler)
+	for _, repo := range repos {
+		if r.accept != nil && !r.accept(repo) {
+			continue
+		}
+		key := strings.ToLower(repo.FullName())
+		if h, ok := r.handlers[key]; ok {
+			h.SetRepository(repo)
+			handlers[key] = h
+			continue
+		}
+		handlers[key] = NewMessageHandler(r.client, repo, r.pauseLock, r.options)
+	}
+	r.handlers = handlers
+}
+
+// SetCatchAll sets the repository for payloads which relate to none of the
+// repositories served by the router, or removes it if nil. Those payloads are
+// verified against the secret, and rejected when it is empty but the served
+// repositories have secrets of their own: a payload naming an unknown
+// repository must not go around their verification.
+func (r *repositoryRouter) SetCatchAll(repo *storage.Repository, secret string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case repo == nil:
+		r.catchAll = nil
+		return
+	case r.catchAll == nil:
+		r.catchAll = NewMessageHandler(r.client, repo, r.pauseLock, r.options)
+		r.catchAll.catchAll = true
+	default:
+		r.catchAll.SetRepository(repo)
+	}
+
+	required := false
+	for _, h := range r.handlers {
+		if h.Repository().Secret != "" {
+			required = true
+			break
+		}
+	}
+	r.catchAll.setCatchAllSecret(secret, required)
+}
+
+// Route returns the MessageHandler for the repository the payload relates to.
+func (r *repositoryRouter) Route(payload []byte) (*MessageHandler, error) {
+	fullName, err := github.RepositoryFullName(payload)
+	if err != nil {
+		return nil, err
+	}
+	// GitHub repository names are case insensitive.
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.handlers[strings.ToLower(fullName)]; ok {
+		return h, nil
+	}
+	if r.catchAll != nil {
+		return r.catchAll, nil
+	}
+	return nil, fmt.Errorf("no configured repository for %q", fullName)
+}
+
+// topicRouter is the nsq.Handler for the topic shared by multiple
+// repositories: each message is dispatched to the MessageHandler of the
+// repository it relates to.
+type topicRouter struct {
+	*repositoryRouter
+	topic string
+}
+
+func (t *topicRouter) HandleMessage(n *nsq.Message) error {
+	var p github.PartialMessage
+	if err := json.Unmarshal(n.Body, &p); err != nil {
+		log.Error(err)
+		return nil // No need to retry
+	}
+
+	handler, err := t.Route(p.RawPayload(n.Body))
+	if err != nil {
+		count := incrStat(t.topic, StatUnrouted)
+		log.Debugf("ignoring message %q from topic %q: %v (%d ignored so far)", p.GitHubDelivery, t.topic, err, count)
+		return nil // No need to retry
+	}
+	return handler.HandleMessage(n)
+}
@@ -26,46 +26,103 @@ var runCommand = cli.Command{
 
 func doRunCommand(c *cli.Context) {
 	config := ParseConfigOrDie(c.GlobalString("config"))
-	client := github.NewClient(config.GitHubAPIToken)
+	client := NewGitHubClient(config)
+	discoverRepositoriesOrDie(client, config)
 
-	// Create and start monitoring queues.
+	// Create the queues and pollers, and start monitoring them.
 	lock := sync.RWMutex{}
-	queues := createQueues(client, config, &lock)
-	stopChan := monitorQueues(queues)
+	sources := newSourceSet(client, &lock, newLiveOptionsOrDie(config), OpenStateOrDie(config), true)
+	if err := sources.Reconcile(config); err != nil {
+		logrus.Fatal(err)
+	}
+	runLoop(client, c.GlobalString("config"), config, &lock, sources)
+}
 
-	// Graceful stop on SIGTERM and SIGINT.
+// runLoop runs the periodic synchronization jobs until the live events sources
+// are stopped and all of them exited. Upon reception of SIGTERM or SIGINT, the sources are requested
+// to stop gracefully. Upon reception of SIGHUP, the configuration is reloaded
+// from the specified file.
+func runLoop(client *gh.Client, filename string, config *Config, lock *sync.RWMutex, sources *sourceSet) {
+	stopChan := sources.Done()
+
+	// Graceful stop on SIGTERM and SIGINT, reload on SIGHUP.
 	s := make(chan os.Signal, 64)
-	signal.Notify(s, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(s, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 
-	// Compute next tick time for the synchronization event.
-	nextTickTime := resetNextTickTime(config.PeriodicSync)
+	// Compute next tick time for the synchronization event. The timer isn't
+	// affected by configuration reloads.
+	tick := time.NewTimer(resetNextTickTime(config.PeriodicSync))
 	for {
 		select {
 		case <-stopChan:
-			logrus.Debug("All queues exited")
+			logrus.Debug("All sources exited")
 			return
 		case sig := <-s:
 			logrus.WithField("signal", sig).Debug("received signal")
-			for _, q := range queues {
-				q.Consumer.Stop()
+			if sig == syscall.SIGHUP {
+				if newConfig, err := reloadConfig(client, filename, lock, sources); err != nil {
+					logrus.Errorf("rejecting configuration reload: %v", err)
+				} else {
+					config = newConfig
+				}
+				continue
 			}
-		case <-time.After(nextTickTime):
+			sources.Stop()
+		case <-tick.C:
 			lock.Lock() // Take a write lock, which pauses all queue processing.
+			logrus.Infof("Live events statistics: %s", liveStats.String())
+			if err := discoverRepositories(client, config); err != nil {
+				logrus.Errorf("failed to discover repositories: %v", err)
+			}
+			if err := sources.Reconcile(config); err != nil {
+				logrus.Errorf("failed to update live events sources: %v", err)
+			}
 			logrus.Infof("Starting periodic sync")
 			runPeriodicSync(client, config)
-			nextTickTime = resetNextTickTime(config.PeriodicSync)
+			tick.Reset(resetNextTickTime(config.PeriodicSync))
 			lock.Unlock()
 		}
 	}
 }
 
+// reloadConfig parses and validates the configuration file, and swaps the
+// repositories of all live events sources at once. The running configuration
+// is left in place when the new one is invalid.
+func reloadConfig(client *gh.Client, filename string, lock *sync.RWMutex, sources *sourceSet) (*Config, error) {
+	logrus.Infof("reloading configuration file %q", filename)
+	config, err := ParseConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := discoverRepositories(client, config); err != nil {
+		return nil, err
+	}
+
+	lock.Lock() // Take a write lock, which pauses all queue processing.
+	defer lock.Unlock()
+	if err := sources.Reconcile(config); err != nil {
+		logrus.Errorf("failed to update live events sources: %v", err)
+	}
+	logrus.Infof("configuration reloaded with %d repositories", len(config.Repositories))
+	return config, nil
+}
+
 type Queue struct {
 	Consumer *nsq.Consumer
 }
 
+// newConsumerConfig returns the configuration of NSQ consumers. The consumer
+// never gives up on a message by itself: the MessageHandler decides when a
+// failing message is sent to the dead letter spool.
+func newConsumerConfig() *nsq.Config {
+	cfg := nsq.NewConfig()
+	cfg.MaxAttempts = 0
+	return cfg
+}
+
 func NewQueue(config *config.NSQConfig, handler nsq.Handler) (*Queue, error) {
 	logger := log.New(os.Stderr, "", log.Flags())
-	consumer, err := nsq.NewConsumer(config.Topic, config.Channel, nsq.NewConfig())
+	consumer, err := nsq.NewConsumer(config.Topic, config.Channel, newConsumerConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -79,43 +136,20 @@ func NewQueue(config *config.NSQConfig, handler nsq.Handler) (*Queue, error) {
 	return &Queue{Consumer: consumer}, nil
 }
 
-func createQueues(client *gh.Client, c *Config, lock *sync.RWMutex) []*Queue {
-	// Subscribe to the message queues for each repository.
-	queues := make([]*Queue, 0, len(c.Repositories))
-	for _, repo := range c.Repositories {
-		qconf := &config.NSQConfig{
-			Topic:   repo.Topic,
-			Channel: c.NSQ.Channel,
-			Lookupd: c.NSQ.Lookupd,
-		}
-		queue, err := NewQueue(qconf, NewMessageHandler(client, repo, lock))
-		if err != nil {
-			logrus.Fatal(err)
-		}
-		queues = append(queues, queue)
-	}
-	return queues
+// Stop initiates a graceful stop of the NSQ consumer.
+func (q *Queue) Stop() {
+	q.Consumer.Stop()
 }
 
-func monitorQueues(queues []*Queue) <-chan struct{} {
-	// Start one goroutine per queue and monitor the StopChan event.
-	wg := sync.WaitGroup{}
-	for _, q := range queues {
-		wg.Add(1)
-		go func(queue *Queue) {
-			<-queue.Consumer.StopChan
-			logrus.Debug("Queue stop channel signaled")
-			wg.Done()
-		}(q)
-	}
+// Wait blocks until the NSQ consumer is stopped.
+func (q *Queue) Wait() {
+	<-q.Consumer.StopChan
+}
 
-	// Multiplex all queues exit into a single channel we can select on.
-	stopChan := make(chan struct{})
-	go func() {
-		wg.Wait()
-		stopChan <- struct{}{}
-	}()
-	return stopChan
+// usesSharedTopic returns whether the repository receives its live events
+// from the shared topic.
+func usesSharedTopic(repo *storage.Repository) bool {
+	return repo.EventSource() == config.SourceNSQ && repo.Topic == ""
 }
 
 func resetNextTickTime(p config.PeriodicSync) time.Duration {
@@ -125,20 +159,34 @@ func resetNextTickTime(p config.PeriodicSync) time.Duration {
 }
 
 func runPeriodicSync(client *gh.Client, config *Config) {
-	// Get the list of repositories.
-	repos := make([]*storage.Repository, 0, len(config.Repositories))
-	for _, r := range config.Repositories {
-		repos = append(repos, r)
-	}
+	repos := config.RepositoryList()
 
 	// Run a default synchronization job, with the storage type set to
-	// StoreCurrentState (which corresponds to our rolling storage).
+	// StoreCurrentState (which corresponds to our rolling storage). This job
+	// can't be incremental: the state index is periodically rotated, and
+	// needs to be filled with all opened items each time.
 	syncOptions := github.DefaultSyncOptions
-	syncOptions.SleepPerPage = 10 // TODO Tired of getting blacklisted :-)
 	syncOptions.State = github.GitHubStateFilterOpened
 	syncOptions.Storage = storage.StoreCurrentState
+	syncOptions.Enrich = config.Enrichments
+
+	// The milestones and labels catalog is small enough to be refreshed
+	// each time, and the repository itself is recorded in each state index to
+	// provide a time series of its metrics.
+	syncOptions.Targets = []github.SyncTarget{
+		github.SyncTargetItems,
+		github.SyncTargetMilestones,
+		github.SyncTargetLabels,
+		github.SyncTargetRepository,
+	}
 
 	// Create the blobStore and run the syncCommand.
 	blobStore := storage.NewTransformingBlobStore()
 	github.NewSyncCommandWithOptions(client, blobStore, &syncOptions).Run(repos)
+
+	// GitHub only retains the traffic statistics for two weeks, which is
+	// why they need to be collected regularly.
+	if config.Traffic {
+		github.CollectTraffic(client, blobStore, repos)
+	}
 }
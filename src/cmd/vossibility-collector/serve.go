@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+var serveCommand = cli.Command{
+	Name:   "serve",
+	Usage:  "receive and process GitHub events through a webhook endpoint",
+	Action: doServeCommand,
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "listen", Usage: "listen address (overrides the configuration)"},
+	},
+}
+
+// doServeCommand is an alternative to the run command which receives live
+// events directly from GitHub rather than from NSQ.
+func doServeCommand(c *cli.Context) {
+	config := ParseConfigOrDie(c.GlobalString("config"))
+	client := NewGitHubClient(config)
+	discoverRepositoriesOrDie(client, config)
+
+	listen := config.Webhook.Listen
+	if c.IsSet("listen") {
+		listen = c.String("listen")
+	}
+	if listen == "" {
+		log.Fatal("no listen address for the webhook receiver")
+	}
+	path := config.Webhook.Path
+	if path == "" {
+		path = DefaultWebhookPath
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// All handlers share the same pause lock as for the run command.
+	lock := sync.RWMutex{}
+	opts := newLiveOptionsOrDie(config)
+	router := newRepositoryRouter(client, config.RepositoryList(), nil, &lock, opts)
+	mux := http.NewServeMux()
+	server := newWebhookServer(router)
+	mux.Handle(path, server)
+
+	// The expvar package registers its handler on the default mux.
+	mux.Handle("/debug/vars", http.DefaultServeMux)
+
+	// Repositories configured for polling are polled as for the run command,
+	// but NSQ topics are left alone.
+	sources := newSourceSet(client, &lock, opts, OpenStateOrDie(config), false)
+	sources.AddStatic("webhook", newWebhookListener(l, mux, server), router)
+	if err := sources.Reconcile(config); err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("listening for GitHub deliveries on %s%s", listen, path)
+	runLoop(client, c.GlobalString("config"), config, &lock, sources)
+}
+
+// webhookListener is the liveSource for the webhook receiver.
+type webhookListener struct {
+	listener net.Listener
+	done     chan struct{}
+}
+
+// newWebhookListener starts serving HTTP requests on the listener. The
+// deliveries queued by the webhook server are processed before the listener
+// is done.
+func newWebhookListener(l net.Listener, handler http.Handler, server *webhookServer) *webhookListener {
+	w := &webhookListener{
+		listener: l,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		if err := http.Serve(l, handler); err != nil {
+			log.Debugf("webhook server exited: %v", err)
+		}
+		server.Close()
+		close(w.done)
+	}()
+	return w
+}
+
+// Stop closes the listener, which is how we interrupt the server.
+func (w *webhookListener) Stop() {
+	w.listener.Close()
+}
+
+// Wait blocks until the server exits and its queued deliveries are processed.
+func (w *webhookListener) Wait() {
+	<-w.done
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/state"
+	"cmd/vossibility-collector/storage"
+
+	log "github.com/Sirupsen/logrus"
+	gh "github.com/google/go-github/github"
+)
+
+// liveSource is a source of live events.
+type liveSource interface {
+	// Stop initiates a graceful stop of the source.
+	Stop()
+
+	// Wait blocks until the source is stopped.
+	Wait()
+}
+
+// sourceSet is the collection of live events sources for the configured
+// repositories. It is reconciled with the configuration each time it changes:
+// sources are started for new repositories and stopped for removed ones, and
+// the others are given the new Repository instances.
+type sourceSet struct {
+	client    *gh.Client
+	pauseLock *sync.RWMutex
+	options   *liveOptions
+	state     *state.File
+
+	// consumeNSQ tells whether NSQ topics should be subscribed to.
+	consumeNSQ bool
+
+	// sources is keyed by a string which changes whenever the source must be
+	// restarted to take the configuration into account.
+	sources  map[string]liveSource
+	handlers map[string]*MessageHandler
+	routers  map[string]*repositoryRouter
+
+	// static are the sources which aren't managed by the set (such as the
+	// webhook receiver), and staticRouters their routers.
+	static        []liveSource
+	staticRouters []*repositoryRouter
+
+	// stopped is closed by Stop: the set lives until then, even when the
+	// reconciliation momentarily leaves it without any source. The wait group
+	// tracks the sources which haven't exited yet.
+	stopped  chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newSourceSet creates an empty sourceSet.
+func newSourceSet(client *gh.Client, pauseLock *sync.RWMutex, opts *liveOptions, st *state.File, consumeNSQ bool) *sourceSet {
+	return &sourceSet{
+		client:     client,
+		pauseLock:  pauseLock,
+		options:    opts,
+		state:      st,
+		consumeNSQ: consumeNSQ,
+		sources:    make(map[string]liveSource),
+		handlers:   make(map[string]*MessageHandler),
+		routers:    make(map[string]*repositoryRouter),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// isStopped returns whether Stop was called.
+func (s *sourceSet) isStopped() bool {
+	select {
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// AddStatic adds a source which isn't managed by the set, along with its
+// router which is updated on each reconciliation.
+func (s *sourceSet) AddStatic(name string, source liveSource, router *repositoryRouter) {
+	s.monitor(name, source)
+	s.static = append(s.static, source)
+	s.staticRouters = append(s.staticRouters, router)
+}
+
+func (s *sourceSet) add(key string, source liveSource) {
+	s.sources[key] = source
+	s.monitor(key, source)
+}
+
+func (s *sourceSet) monitor(key string, source liveSource) {
+	s.wg.Add(1)
+	go func() {
+		source.Wait()
+		log.Debugf("Source %q stop signaled", key)
+		s.wg.Done()
+	}()
+}
+
+func queueKey(c *config.NSQConfig) string {
+	return fmt.Sprintf("nsq:%s/%s@%s", c.Topic, c.Channel, c.Lookupd)
+}
+
+func pollerKey(repo *storage.Repository) string {
+	return fmt.Sprintf("poll:%s@%s", repo.GivenName, repo.FullName())
+}
+
+// Reconcile starts, updates and stops the sources according to the
+// configuration. It must be called with the pause lock held for writing, so
+// that all repositories are swapped at once. Sources which fail to start are
+// skipped, and the last error is returned. Nothing is started once the set is
+// stopped.
+func (s *sourceSet) Reconcile(c *Config) error {
+	if s.isStopped() {
+		return nil
+	}
+	var lastErr error
+	wanted := make(map[string]struct{})
+
+	if s.consumeNSQ {
+		// Subscribe to the message queues for each repository which has its
+		// own topic: the others are served through the shared topic.
+		for _, repo := range c.Repositories {
+			if repo.EventSource() != config.SourceNSQ || usesSharedTopic(repo) {
+				continue
+			}
+			qconf := &config.NSQConfig{
+				Topic:   repo.Topic,
+				Channel: c.NSQ.Channel,
+				Lookupd: c.NSQ.Lookupd,
+			}
+			key := queueKey(qconf)
+			wanted[key] = struct{}{}
+			if h, ok := s.handlers[key]; ok {
+				h.SetRepository(repo)
+				continue
+			}
+			h := NewMessageHandler(s.client, repo, s.pauseLock, s.options)
+			queue, err := NewQueue(qconf, h)
+			if err != nil {
+				log.Errorf("subscribing to topic %q for repository %s: %v", repo.Topic, repo.PrettyName(), err)
+				lastErr = err
+				continue
+			}
+			s.add(key, queue)
+			s.handlers[key] = h
+		}
+
+		// Subscribe to the shared topic, which routes messages according to
+		// the repository they relate to.
+		if c.NSQ.Topic != "" {
+			key := queueKey(&c.NSQ)
+			wanted[key] = struct{}{}
+			if err := s.reconcileSharedTopic(key, c); err != nil {
+				log.Errorf("subscribing to shared topic %q: %v", c.NSQ.Topic, err)
+				lastErr = err
+			}
+		}
+	}
+
+	// Poll the repositories configured to do so.
+	for _, repo := range c.Repositories {
+		if repo.EventSource() != config.SourcePoll {
+			continue
+		}
+		key := pollerKey(repo)
+		wanted[key] = struct{}{}
+		if h, ok := s.handlers[key]; ok {
+			h.SetRepository(repo)
+			continue
+		}
+		p := newRepositoryPoller(s.client, repo, s.pauseLock, s.options, s.state)
+		s.add(key, p)
+		s.handlers[key] = p.handler
+	}
+
+	for _, r := range s.staticRouters {
+		r.Update(c.RepositoryList())
+	}
+
+	// Stop the sources which are no longer needed.
+	for key, source := range s.sources {
+		if _, ok := wanted[key]; !ok {
+			log.Infof("stopping source %q", key)
+			source.Stop()
+			delete(s.sources, key)
+			delete(s.handlers, key)
+			delete(s.routers, key)
+		}
+	}
+	return lastErr
+}
+
+func (s *sourceSet) reconcileSharedTopic(key string, c *Config) error {
+	var catchAll *storage.Repository
+	if c.NSQ.CatchAll != "" {
+		catchAll = c.Repositories[c.NSQ.CatchAll]
+	}
+
+	if router, ok := s.routers[key]; ok {
+		router.Update(c.RepositoryList())
+		router.SetCatchAll(catchAll, c.NSQ.CatchAllSecret)
+		return nil
+	}
+
+	t := &topicRouter{
+		repositoryRouter: newRepositoryRouter(s.client, c.RepositoryList(), usesSharedTopic, s.pauseLock, s.options),
+		topic:            c.NSQ.Topic,
+	}
+	t.SetCatchAll(catchAll, c.NSQ.CatchAllSecret)
+	queue, err := NewQueue(&c.NSQ, t)
+	if err != nil {
+		return err
+	}
+	s.add(key, queue)
+	s.routers[key] = t.repositoryRouter
+	return nil
+}
+
+// Stop initiates a graceful stop of all sources. It must be called from the
+// same goroutine as Reconcile and AddStatic.
+func (s *sourceSet) Stop() {
+	s.stopOnce.Do(func() {
+		for _, source := range s.sources {
+			source.Stop()
+		}
+		for _, source := range s.static {
+			source.Stop()
+		}
+		close(s.stopped)
+	})
+}
+
+// Done returns a channel which is closed once the set is stopped and all of
+// its sources exited.
+func (s *sourceSet) Done() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		// No source is added once stopped, which makes it safe to wait.
+		<-s.stopped
+		s.wg.Wait()
+		close(done)
+	}()
+	return done
+}
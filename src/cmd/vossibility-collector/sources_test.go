@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSource struct {
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newTestSource() *testSource {
+	return &testSource{done: make(chan struct{})}
+}
+
+func (t *testSource) Stop() {
+	t.stopOnce.Do(func() { close(t.done) })
+}
+
+func (t *testSource) Wait() {
+	<-t.done
+}
+
+func TestSourceSetLifetime(t *testing.T) {
+	sources := newSourceSet(nil, &sync.RWMutex{}, &liveOptions{}, nil, false)
+	source := newTestSource()
+	sources.add("test", source)
+	done := sources.Done()
+
+	// A reconciliation which stops all sources doesn't end the set.
+	if err := sources.Reconcile(&Config{}); err != nil {
+		t.Fatalf("unexpected error reconciling sources: %v", err)
+	}
+	source.Wait()
+	select {
+	case <-done:
+		t.Fatal("set done without being stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Sources added later are waited for once stopped.
+	other := newTestSource()
+	sources.add("other", other)
+	sources.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("set not done after being stopped")
+	}
+	select {
+	case <-other.done:
+	default:
+		t.Fatal("source not stopped with the set")
+	}
+}
@@ -0,0 +1,137 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// File is a key-value store for the small pieces of state the collector needs
+// to persist across restarts (such as polling cursors). The whole content is
+// held in memory, and written back to disk as a single JSON object on every
+// modification.
+//
+// Several processes (such as the `run` and `sync` commands) can share the same
+// file: modifications are made under an exclusive lock of the file, and apply
+// to its content as read again from disk, so that the keys modified by the
+// other processes are preserved.
+//
+// A File with an empty path is never persisted.
+type File struct {
+	mu   sync.Mutex
+	path string
+	data map[string]json.RawMessage
+}
+
+// Open loads the state from the specified path. A missing file is not an
+// error, as it simply corresponds to an empty state.
+func Open(path string) (*File, error) {
+	data, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, data: data}, nil
+}
+
+// load reads the state from the specified path.
+func load(path string) (map[string]json.RawMessage, error) {
+	data := make(map[string]json.RawMessage)
+	if path == "" {
+		return data, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// IsPersistent returns whether the state is saved to disk.
+func (f *File) IsPersistent() bool {
+	return f.path != ""
+}
+
+// Get unmarshals the value for key into v, and returns whether the key exists.
+func (f *File) Get(key string, v interface{}) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.data[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(b, v)
+}
+
+// Set stores the value for key and saves the state.
+func (f *File) Set(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return f.update(func(data map[string]json.RawMessage) {
+		data[key] = b
+	})
+}
+
+// Delete removes the key and saves the state.
+func (f *File) Delete(key string) error {
+	return f.update(func(data map[string]json.RawMessage) {
+		delete(data, key)
+	})
+}
+
+// update applies the modification to the state and saves it. The state is
+// read again from disk under the lock of the file, so that the modifications
+// of the other processes sharing it aren't lost.
+func (f *File) update(modify func(map[string]json.RawMessage)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.path == "" {
+		modify(f.data)
+		return nil
+	}
+
+	// The state file itself is replaced on each save, hence the separate
+	// lock file.
+	lock, err := os.OpenFile(f.path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	data, err := load(f.path)
+	if err != nil {
+		return err
+	}
+	modify(data)
+	if err := save(f.path, data); err != nil {
+		return err
+	}
+	f.data = data
+	return nil
+}
+
+// save writes the state to disk, going through a temporary file in order not
+// to corrupt the existing state in case of failure.
+func save(path string, data map[string]json.RawMessage) error {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,111 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testValue struct {
+	Cursor int64
+	ETag   string
+}
+
+func TestFileInMemory(t *testing.T) {
+	f, err := Open("")
+	if err != nil {
+		t.Fatalf("failed to open in-memory state: %v", err)
+	}
+	if f.IsPersistent() {
+		t.Fatal("in-memory state reported as persistent")
+	}
+
+	var v testValue
+	if ok, err := f.Get("key", &v); ok || err != nil {
+		t.Fatalf("unexpected result for missing key (ok=%v, err=%v)", ok, err)
+	}
+	if err := f.Set("key", testValue{42, "etag"}); err != nil {
+		t.Fatalf("failed to set key: %v", err)
+	}
+	if ok, err := f.Get("key", &v); !ok || err != nil {
+		t.Fatalf("unexpected result for existing key (ok=%v, err=%v)", ok, err)
+	} else if v != (testValue{42, "etag"}) {
+		t.Fatalf("unexpected value %#v", v)
+	}
+	if err := f.Delete("key"); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+	if ok, _ := f.Get("key", &v); ok {
+		t.Fatal("unexpected value for deleted key")
+	}
+}
+
+func TestFilePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open missing state: %v", err)
+	}
+	if err := f.Set("key", testValue{42, "etag"}); err != nil {
+		t.Fatalf("failed to set key: %v", err)
+	}
+
+	if f, err = Open(path); err != nil {
+		t.Fatalf("failed to reopen state: %v", err)
+	}
+	var v testValue
+	if ok, err := f.Get("key", &v); !ok || err != nil {
+		t.Fatalf("unexpected result after reopening (ok=%v, err=%v)", ok, err)
+	} else if v != (testValue{42, "etag"}) {
+		t.Fatalf("unexpected value %#v after reopening", v)
+	}
+}
+
+func TestFileShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	// Both files are opened before either is modified, as when the run and
+	// sync commands share the state.
+	a, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	b, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	if err := a.Set("a", testValue{1, "a"}); err != nil {
+		t.Fatalf("failed to set key: %v", err)
+	}
+	if err := b.Set("b", testValue{2, "b"}); err != nil {
+		t.Fatalf("failed to set key: %v", err)
+	}
+	if err := a.Delete("c"); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to reopen state: %v", err)
+	}
+	for key, expected := range map[string]testValue{"a": {1, "a"}, "b": {2, "b"}} {
+		var v testValue
+		if ok, err := f.Get(key, &v); !ok || err != nil {
+			t.Fatalf("unexpected result for key %q (ok=%v, err=%v)", key, ok, err)
+		} else if v != expected {
+			t.Fatalf("unexpected value %#v for key %q", v, key)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"expvar"
+)
+
+const (
+	// StatRejectedSignature counts the live events rejected because of an
+	// invalid signature.
+	StatRejectedSignature = "rejected_signature"
+
+	// StatDeadLettered counts the live events sent to the dead letter spool.
+	StatDeadLettered = "dead_lettered"
+
+	// StatDuplicates counts the live events ignored because their delivery
+	// was already processed.
+	StatDuplicates = "duplicates"
+
+	// StatUnrouted counts the messages from a shared topic which relate to
+	// none of the configured repositories. This counter is kept per topic.
+	StatUnrouted = "unrouted"
+)
+
+// liveStats holds per-repository counters about live events processing. It is
+// published through expvar, and therefore exposed on the "/debug/vars" path of
+// the webhook receiver.
+var liveStats = expvar.NewMap("live_events")
+
+// incrStat increments the counter for the repository (or topic) and returns
+// its new value.
+func incrStat(repoName, stat string) int64 {
+	key := repoName + "." + stat
+	liveStats.Add(key, 1)
+	if v, ok := liveStats.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
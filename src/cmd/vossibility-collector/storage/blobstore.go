@@ -26,6 +26,10 @@ const (
 
 	// StoreLiveEvent corresponds to the rolling index of events.
 	StoreLiveEvent
+
+	// StoreTraffic corresponds to the non-expiring index of the traffic
+	// statistics of the repository.
+	StoreTraffic
 )
 
 // BlobStore determines from the Storage and Repository how the Blob should be
@@ -43,6 +47,22 @@ type transformations struct {
 	transformations transformation.Transformations
 }
 
+// snapshotTransformations associates the types of data retrieved by
+// synchronization jobs with the event set entry holding their transformation.
+var snapshotTransformations = map[string]string{
+	config.GitHubTypeIssue:         config.SnapshotIssueType,
+	config.GitHubTypePullRequest:   config.SnapshotPullRequestType,
+	config.GitHubTypeIssueComment:  config.SnapshotIssueCommentType,
+	config.GitHubTypeReviewComment: config.SnapshotReviewCommentType,
+	config.GitHubTypeIssueEvent:    config.SnapshotIssueEventType,
+	config.GitHubTypeRelease:       config.SnapshotReleaseType,
+	config.GitHubTypeTag:           config.SnapshotTagType,
+	config.GitHubTypeMilestone:     config.SnapshotMilestoneType,
+	config.GitHubTypeLabel:         config.SnapshotLabelType,
+	config.GitHubTypeWorkflowRun:   config.SnapshotWorkflowRunType,
+	config.GitHubTypeRepository:    config.SnapshotRepositoryType,
+}
+
 // NewTransformingBlobStore creates a new transformingBlobStore backed by a
 // simpleBlobStore.
 func NewTransformingBlobStore() BlobStore {
@@ -84,18 +104,20 @@ func (b *transformingBlobStore) getTransformation(storage Storage, repo *Reposit
 		return repo.EventSet[event]
 	}
 
-	// This is not a live event: we have hardcoded transformations for the
-	// issues and pull requests data types.
-	switch event {
-	case config.GitHubTypeIssue:
-		return repo.EventSet[config.SnapshotIssueType]
-	case config.GitHubTypePullRequest:
-		return repo.EventSet[config.SnapshotPullRequestType]
-	default:
-		// No transformation for that event type.
-		log.Warnf("no transformation found for event type %q", event)
+	// Traffic statistics are built by the collector in their final shape.
+	if storage == StoreTraffic {
 		return nil
 	}
+
+	// This is not a live event: each data type retrieved by synchronization
+	// jobs has a dedicated event set entry.
+	if name, ok := snapshotTransformations[event]; ok {
+		return repo.EventSet[name]
+	}
+
+	// No transformation for that event type.
+	log.Warnf("no transformation found for event type %q", event)
+	return nil
 }
 
 // NewSimpleBlobStore creates a new simpleBlobStore.
@@ -149,6 +171,13 @@ func (b *simpleBlobStore) Store(storage Storage, repo *Repository, blob *blob.Bl
 		if err := b.indexer.Index(repo.SnapshotIndex(), blob); err != nil {
 			return fmt.Errorf("store snapshot %s data: %v", blob.ID, err)
 		}
+	// Traffic is an index containing the traffic statistics of the
+	// repository, which GitHub only retains for a limited time.
+	case StoreTraffic:
+		log.Debugf("store traffic to %s/%s/%s", repo.TrafficIndex(), blob.Type, blob.ID)
+		if err := b.indexer.Index(repo.TrafficIndex(), blob); err != nil {
+			return fmt.Errorf("store traffic %s data: %v", blob.ID, err)
+		}
 	}
 	return nil
 }
@@ -103,3 +103,17 @@ func TestSimpleBlobStoreCascading(t *testing.T) {
 		t.Fatalf("cascading live event to %q, expected %q", cascading, destSnapshot)
 	}
 }
+
+func TestSimpleBlobStoreTraffic(t *testing.T) {
+	s, indexer := simpleBlobStoreSetup()
+	b := blob.NewBlob("traffic_views", "2016-01-02")
+	if err := s.Store(StoreTraffic, &testRepository, b); err != nil {
+		t.Fatalf("failed to store blob: %v", err)
+	}
+	if indexer.Len() != 1 {
+		t.Fatalf("indexer was called %d times, expected once", indexer.Len())
+	}
+	if dest := (*indexer)[0].Destination; dest != "testrepo-traffic" {
+		t.Fatalf("stored traffic to %q, expected %q", dest, "testrepo-traffic")
+	}
+}
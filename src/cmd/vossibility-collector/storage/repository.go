@@ -158,6 +158,12 @@ func (r *Repository) SnapshotIndex() string {
 	return fmt.Sprintf("%ssnapshot", r.IndexPrefix())
 }
 
+// TrafficIndex returns the Elastic Search index appropriate to store this
+// repository's traffic statistics.
+func (r *Repository) TrafficIndex() string {
+	return fmt.Sprintf("%straffic", r.IndexPrefix())
+}
+
 // IsSubscribed returns whether we should subscribe for a particular GitHub
 // event type for this repository.
 func (r *Repository) IsSubscribed(event string) bool {
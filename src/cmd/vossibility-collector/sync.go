@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"cmd/vossibility-collector/github"
 	"cmd/vossibility-collector/storage"
@@ -16,17 +19,62 @@ var syncCommand = cli.Command{
 	Action: doSyncCommand,
 	Flags: []cli.Flag{
 		cli.IntFlag{Name: "from", Value: 1, Usage: "issue number to start from"},
-		cli.IntFlag{Name: "sleep", Value: 0, Usage: "sleep delay between each GitHub page queried"},
+		cli.IntFlag{Name: "to", Usage: "issue number to stop at (inclusive)"},
+		cli.StringFlag{Name: "numbers", Usage: "comma-separated list of issue numbers to sync instead of listing all items"},
+		cli.StringFlag{Name: "only", Usage: "sync only the issues or the pull requests (issues, pulls)"},
+		cli.StringFlag{Name: "state", Value: "all", Usage: "state of the items to sync (open, closed, all)"},
+		cli.StringFlag{Name: "storage", Value: "snapshot", Usage: "destination of the items (snapshot, state)"},
+		cli.IntFlag{Name: "per-page", Value: github.DefaultPerPage, Usage: "number of items per GitHub page queried"},
+		cli.StringFlag{Name: "since", Usage: "sync the items updated since the date (YYYY-MM-DD or RFC 3339), ignoring the checkpoints"},
+		cli.IntFlag{Name: "sleep", Value: 0, Usage: "additional sleep delay between each GitHub page queried"},
+		cli.BoolFlag{Name: "full", Usage: "ignore the checkpoints and run a complete synchronization"},
+		cli.BoolFlag{Name: "resume", Usage: "resume the interrupted synchronization of each repository"},
+		cli.IntFlag{Name: "parallel", Value: github.DefaultNumRepoProcs, Usage: "number of repositories synced in parallel"},
+		cli.StringFlag{Name: "targets", Value: "items,comments", Usage: "comma-separated list of data to sync (items, comments, timeline, stargazers, forks, releases, milestones, labels, workflows, repository)"},
+		cli.StringFlag{Name: "enrich", Usage: "comma-separated list of additional pull request data to sync (reviews, ci), overriding the configuration"},
 	},
 }
 
 // doSyncCommand runs a synchronization job: it fetches all GitHub issues and
 // pull requests starting with the From index. It uses the API pagination to
-// reduce API calls, and allows a Sleep delay between each page to avoid
-// triggering the abuse detection mechanism.
+// reduce API calls, and allows an additional Sleep delay between each page on
+// top of the scheduling of requests according to the GitHub rate limit.
+//
+// Unless the full synchronization is requested, only the items updated since
+// the checkpoint of each repository are fetched. The progress of the job is
+// recorded so that it can be resumed if interrupted.
+//
+// The data retrieved for each repository is selected by the list of targets:
+// issues and pull requests ("items"), their comments ("comments") and their
+// events ("timeline"), as well as the stargazers ("stargazers"), forks
+// ("forks"), releases and tags ("releases"), milestones ("milestones"), labels
+// ("labels") and workflow runs ("workflows") of the repository, and the
+// repository itself ("repository"). Pull requests are enriched with the
+// additional data configured or requested on the command line.
+//
+// Items can be restricted to a range or a list of numbers, to issues or pull
+// requests, to a state, or to those updated since a date, which allows to
+// repair specific items without a complete synchronization. Such partial
+// synchronizations don't record any checkpoint nor progress.
 func doSyncCommand(c *cli.Context) {
+	targets, err := github.ParseSyncTargets(c.String("targets"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	filters, err := parseSyncFilters(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Repairing specific items doesn't require the other targets, unless
+	// explicitly requested.
+	if len(filters.Numbers) != 0 && !c.IsSet("targets") {
+		targets = []github.SyncTarget{github.SyncTargetItems}
+	}
+
 	config := ParseConfigOrDie(c.GlobalString("config"))
-	client := github.NewClient(config.GitHubAPIToken)
+	client := NewGitHubClient(config)
+	discoverRepositoriesOrDie(client, config)
 	blobStore := storage.NewTransformingBlobStore()
 
 	// Get the list of repositories from command-line (defaults to all).
@@ -49,14 +97,99 @@ func doSyncCommand(c *cli.Context) {
 	}
 
 	// Configure a syncJob taking all issues (opened and closed) and storing
-	// in the snapshot store.
+	// in the snapshot store, unless filtered on the command line.
 	syncOptions := github.DefaultSyncOptions
 	syncOptions.From = c.Int("from")
+	syncOptions.To = filters.To
+	syncOptions.Numbers = filters.Numbers
+	syncOptions.Only = filters.Only
+	syncOptions.Since = filters.Since
+	syncOptions.PerPage = filters.PerPage
 	syncOptions.SleepPerPage = c.Int("sleep")
-	syncOptions.State = github.GitHubStateFilterAll
-	syncOptions.Storage = storage.StoreSnapshot
+	syncOptions.NumRepoProcs = c.Int("parallel")
+	syncOptions.State = filters.State
+	syncOptions.Storage = filters.Storage
+	syncOptions.Checkpoints = OpenStateOrDie(config)
+	syncOptions.Incremental = !c.Bool("full")
+	syncOptions.Resume = c.Bool("resume")
+	syncOptions.Targets = targets
+	syncOptions.Enrich = config.Enrichments
+	if c.IsSet("enrich") {
+		if syncOptions.Enrich, err = github.ParseEnrichments(strings.Split(c.String("enrich"), ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Create and run the synchronization job.
 	log.Warnf("running sync jobs on repositories %s", strings.Join(repoToSync, ", "))
 	github.NewSyncCommandWithOptions(client, blobStore, &syncOptions).Run(repos)
 }
+
+// syncFilters is the selection of items requested on the command line.
+type syncFilters struct {
+	To      int
+	Numbers []int
+	Only    github.GitHubItemFilter
+	State   github.GitHubStateFilter
+	Storage storage.Storage
+	PerPage int
+	Since   time.Time
+}
+
+// parseSyncFilters validates the item selection flags of the sync command.
+func parseSyncFilters(c *cli.Context) (*syncFilters, error) {
+	f := &syncFilters{
+		To:      c.Int("to"),
+		PerPage: c.Int("per-page"),
+	}
+	if f.To != 0 && f.To < c.Int("from") {
+		return nil, fmt.Errorf("invalid range: --to %d is lower than --from %d", f.To, c.Int("from"))
+	}
+	if f.PerPage < 1 || f.PerPage > 100 {
+		return nil, fmt.Errorf("invalid page size %d: must be between 1 and 100", f.PerPage)
+	}
+
+	for _, n := range strings.Split(c.String("numbers"), ",") {
+		if n = strings.TrimSpace(n); n == "" {
+			continue
+		}
+		number, err := strconv.Atoi(n)
+		if err != nil || number < 1 {
+			return nil, fmt.Errorf("invalid issue number %q", n)
+		}
+		f.Numbers = append(f.Numbers, number)
+	}
+
+	switch only := github.GitHubItemFilter(c.String("only")); only {
+	case github.GitHubItemFilterAll, github.GitHubItemFilterIssues, github.GitHubItemFilterPulls:
+		f.Only = only
+	default:
+		return nil, fmt.Errorf("invalid item filter %q (expected issues or pulls)", only)
+	}
+
+	switch state := github.GitHubStateFilter(c.String("state")); state {
+	case github.GitHubStateFilterAll, github.GitHubStateFilterClosed, github.GitHubStateFilterOpened:
+		f.State = state
+	default:
+		return nil, fmt.Errorf("invalid state %q (expected open, closed or all)", state)
+	}
+
+	switch c.String("storage") {
+	case "snapshot":
+		f.Storage = storage.StoreSnapshot
+	case "state":
+		f.Storage = storage.StoreCurrentState
+	default:
+		return nil, fmt.Errorf("invalid storage %q (expected snapshot or state)", c.String("storage"))
+	}
+
+	if since := c.String("since"); since != "" {
+		var err error
+		if f.Since, err = time.Parse("2006-01-02", since); err != nil {
+			if f.Since, err = time.Parse(time.RFC3339, since); err != nil {
+				return nil, fmt.Errorf("invalid date %q (expected YYYY-MM-DD or RFC 3339)", since)
+			}
+		}
+	}
+	return f, nil
+}
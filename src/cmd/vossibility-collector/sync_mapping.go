@@ -53,6 +53,7 @@ func notAnalyzedStringProto(pattern string) mappingProto {
 // Search backend mappings.
 func doSyncMapping(c *cli.Context) {
 	config := ParseConfigOrDie(c.GlobalString("config"))
+	discoverRepositoriesOrDie(NewGitHubClient(config), config)
 
 	notAnalyzedProtos := []mappingProto{}
 	for _, notAnalyzedPattern := range config.NotAnalyzedPatterns {
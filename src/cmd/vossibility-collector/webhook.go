@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"cmd/vossibility-collector/github"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// DefaultWebhookPath is the URL path at which GitHub deliveries are
+	// accepted when left unspecified in the configuration.
+	DefaultWebhookPath = "/"
+
+	// MaxWebhookPayloadSize is the maximum size of an accepted delivery.
+	// GitHub caps payloads to 25MB.
+	MaxWebhookPayloadSize = 25 << 20
+
+	// WebhookQueueSize is the maximum number of accepted deliveries waiting
+	// to be processed.
+	WebhookQueueSize = 64
+
+	// WebhookQueueTimeout is how long a delivery waits for room in the queue
+	// before being sent to the dead letter spool. GitHub expects a response
+	// within 10 seconds.
+	WebhookQueueTimeout = 5 * time.Second
+
+	// WebhookNumProcs is the number of goroutines processing accepted
+	// deliveries.
+	WebhookNumProcs = 4
+)
+
+// errWebhookQueueFull is the cause recorded for the deliveries sent to the dead
+// letter spool because the queue was full.
+var errWebhookQueueFull = errors.New("too many pending deliveries")
+
+// webhookServer is an HTTP handler receiving GitHub webhook deliveries, and
+// forwarding them to the MessageHandler of the appropriate repository.
+//
+// GitHub doesn't redeliver failed deliveries: those which can't be queued nor
+// processed go to the dead letter spool.
+type webhookServer struct {
+	router       *repositoryRouter
+	queueTimeout time.Duration
+
+	// The queue is closed once the server is stopped, and closed is set
+	// under the lock so that no delivery is sent to it after that.
+	mu         sync.RWMutex
+	closed     bool
+	deliveries chan webhookDelivery
+	procs      sync.WaitGroup
+}
+
+// webhookDelivery is an accepted delivery waiting to be processed.
+type webhookDelivery struct {
+	handler   *MessageHandler
+	timestamp int64
+	event     string
+	delivery  string
+	payload   []byte
+}
+
+// newWebhookServer creates a webhookServer, and starts the goroutines
+// processing its accepted deliveries.
+func newWebhookServer(router *repositoryRouter) *webhookServer {
+	return newWebhookServerWithQueue(router, WebhookQueueSize, WebhookQueueTimeout)
+}
+
+func newWebhookServerWithQueue(router *repositoryRouter, size int, timeout time.Duration) *webhookServer {
+	s := &webhookServer{
+		router:       router,
+		queueTimeout: timeout,
+		deliveries:   make(chan webhookDelivery, size),
+	}
+	for i := 0; i != WebhookNumProcs; i++ {
+		s.procs.Add(1)
+		go s.processingProc()
+	}
+	return s
+}
+
+// Close stops accepting deliveries into the queue, and blocks until the
+// queued ones are processed.
+func (s *webhookServer) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.deliveries)
+	}
+	s.mu.Unlock()
+	s.procs.Wait()
+}
+
+// processingProc handles the accepted deliveries.
+func (s *webhookServer) processingProc() {
+	for d := range s.deliveries {
+		// Nothing will redeliver the event in case of failure, so we send it
+		// straight to the dead letter spool.
+		if err := d.handler.HandleDelivery(d.timestamp, d.event, d.delivery, d.payload); err != nil {
+			log.Errorf("handling delivery %q for event %q: %v", d.delivery, d.event, err)
+			d.handler.SendToDeadLetter(d.timestamp, d.event, d.delivery, d.payload, 1, err)
+		}
+	}
+	s.procs.Done()
+}
+
+// enqueue queues the delivery, waiting for room in the queue for a limited
+// time. It returns false if the delivery couldn't be queued.
+func (s *webhookServer) enqueue(d webhookDelivery) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false
+	}
+	timer := time.NewTimer(s.queueTimeout)
+	defer timer.Stop()
+	select {
+	case s.deliveries <- d:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	event := req.Header.Get("X-GitHub-Event")
+	delivery := req.Header.Get("X-GitHub-Delivery")
+	if event == "" || delivery == "" {
+		http.Error(w, "missing GitHub headers", http.StatusBadRequest)
+		return
+	}
+
+	// GitHub sends a ping event when a hook is first configured: there is
+	// nothing to process, but we still want it to succeed.
+	if event == "ping" {
+		log.Infof("received ping for delivery %q", delivery)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, MaxWebhookPayloadSize))
+	if err != nil {
+		http.Error(w, "failed to read payload", http.StatusBadRequest)
+		return
+	}
+
+	handler, err := s.router.Route(payload)
+	if err != nil {
+		log.Warnf("rejecting delivery %q for event %q: %v", delivery, event, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	signatures := github.PartialMessage{
+		HubSignature:    req.Header.Get("X-Hub-Signature"),
+		HubSignature256: req.Header.Get("X-Hub-Signature-256"),
+	}
+	if err := handler.VerifySignature(signatures.Signature(), payload); err != nil {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	// GitHub expects a response within 10 seconds, which we can't guarantee
+	// when processing is paused for a periodic sync: the delivery is handled
+	// asynchronously once accepted. When too many deliveries are waiting (or
+	// when the server is stopping), it goes to the dead letter spool, as
+	// GitHub won't redeliver it.
+	d := webhookDelivery{handler, time.Now().UnixNano(), event, delivery, payload}
+	if s.enqueue(d) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err := handler.SendToDeadLetter(d.timestamp, event, delivery, payload, 0, errWebhookQueueFull); err != nil {
+		log.Errorf("dropping delivery %q for event %q: %v", delivery, event, errWebhookQueueFull)
+		http.Error(w, errWebhookQueueFull.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
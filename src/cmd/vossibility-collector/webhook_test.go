@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cmd/vossibility-collector/blob"
+	"cmd/vossibility-collector/config"
+	"cmd/vossibility-collector/deadletter"
+	"cmd/vossibility-collector/storage"
+)
+
+type countingBlobStore struct {
+	sync.Mutex
+	count int
+}
+
+func (c *countingBlobStore) Store(storage.Storage, *storage.Repository, *blob.Blob) error {
+	c.Lock()
+	defer c.Unlock()
+	c.count++
+	return nil
+}
+
+func TestWebhookServerQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := deadletter.NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &storage.Repository{
+		RepositoryConfig: config.RepositoryConfig{User: "icecrime", Repo: "repo"},
+		GivenName:        "repo",
+		EventSet:         storage.EventSet{"push": nil},
+	}
+	lock := &sync.RWMutex{}
+	router := newRepositoryRouter(nil, []*storage.Repository{repo}, nil, lock, &liveOptions{DeadLetter: spool, MaxAttempts: 1})
+	store := &countingBlobStore{}
+	for _, h := range router.handlers {
+		h.store = store
+	}
+
+	// Processing is paused, as during a periodic sync: the deliveries which
+	// don't fit in the queue go to the dead letter spool.
+	lock.Lock()
+	s := newWebhookServerWithQueue(router, 1, 10*time.Millisecond)
+	const numDeliveries = 10
+	for i := 0; i != numDeliveries; i++ {
+		req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(`{"repository": {"full_name": "icecrime/repo"}}`))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", fmt.Sprintf("delivery-%d", i))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("unexpected status %d for delivery %d", w.Code, i)
+		}
+	}
+	records, err := spool.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < numDeliveries-WebhookNumProcs-1 {
+		t.Fatalf("unexpected number of spooled deliveries %d", len(records))
+	}
+
+	// Closing the server waits for the queued deliveries to be processed.
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("server closed before processing its queued deliveries")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lock.Unlock()
+	<-closed
+	if store.count+len(records) != numDeliveries {
+		t.Fatalf("%d deliveries stored and %d spooled, expected %d in total", store.count, len(records), numDeliveries)
+	}
+}